@@ -0,0 +1,104 @@
+package sqlb
+
+import (
+	"cmp"
+	"context"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+type sqlCommentTag struct {
+	key, value string
+}
+
+type sqlComment struct {
+	tags   []sqlCommentTag
+	caller bool
+}
+
+type sqlCommentContextKey struct{}
+
+// WithSQLComment returns a context that will append key=value to outgoing SQL in a sqlcommenter-style comment,
+// such as /*route='%2Fusers',trace='abc'*/. Tags are sorted by key, and a later call with the same key replaces the value.
+//
+// Comments are ignored by [Fingerprint]. Queries run through a [StmtCache] carry no comment, since the statements
+// are prepared once and shared, and per-request values like trace IDs would otherwise create a statement per request.
+// Use a handle without a cache for queries that need to be correlated with database logs.
+func WithSQLComment(ctx context.Context, key, value string) context.Context {
+	c := sqlCommentFrom(ctx)
+	c.tags = slices.DeleteFunc(slices.Clone(c.tags), func(t sqlCommentTag) bool { return t.key == key })
+	c.tags = append(c.tags, sqlCommentTag{key, value})
+	slices.SortFunc(c.tags, func(a, b sqlCommentTag) int { return cmp.Compare(a.key, b.key) })
+	return context.WithValue(ctx, sqlCommentContextKey{}, c)
+}
+
+// WithSQLCommentCaller returns a context that will add a "caller" tag to the SQL comment, naming the function
+// that called into sqlb, such as caller='users.(*Store).List'.
+func WithSQLCommentCaller(ctx context.Context) context.Context {
+	c := sqlCommentFrom(ctx)
+	c.caller = true
+	return context.WithValue(ctx, sqlCommentContextKey{}, c)
+}
+
+func sqlCommentFrom(ctx context.Context) sqlComment {
+	c, _ := ctx.Value(sqlCommentContextKey{}).(sqlComment)
+	return c
+}
+
+type sqlCommentAddedContextKey struct{}
+
+// sqlCommentAdded is a query before and after [appendSQLComment], so that [StmtCache] can remove exactly
+// the comment sqlb added, and never one written by the user.
+type sqlCommentAdded struct {
+	query, commented string
+}
+
+// appendSQLComment appends the context's sqlcommenter comment to query, before any trailing semicolon.
+// The returned context records the comment for [trimSQLComment].
+func appendSQLComment(ctx context.Context, query string) (context.Context, string) {
+	c := sqlCommentFrom(ctx)
+	if len(c.tags) == 0 && !c.caller {
+		return ctx, query
+	}
+
+	tags := c.tags
+	if c.caller {
+		tags = append(slices.Clone(tags), sqlCommentTag{"caller", caller()})
+		slices.SortStableFunc(tags, func(a, b sqlCommentTag) int { return cmp.Compare(a.key, b.key) })
+	}
+
+	trimmed := strings.TrimRight(query, " \t\n;")
+	suffix := query[len(trimmed):]
+
+	var b strings.Builder
+	b.WriteString(trimmed)
+	b.WriteString(" /*")
+	for i, t := range tags {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(sqlCommentEscape(t.key))
+		b.WriteString("='")
+		b.WriteString(sqlCommentEscape(t.value))
+		b.WriteByte('\'')
+	}
+	b.WriteString("*/")
+	b.WriteString(strings.TrimLeft(suffix, " \t\n"))
+
+	commented := b.String()
+	return context.WithValue(ctx, sqlCommentAddedContextKey{}, sqlCommentAdded{query, commented}), commented
+}
+
+// trimSQLComment returns query without the comment added to it by [appendSQLComment], if any.
+func trimSQLComment(ctx context.Context, query string) string {
+	if a, ok := ctx.Value(sqlCommentAddedContextKey{}).(sqlCommentAdded); ok && a.commented == query {
+		return a.query
+	}
+	return query
+}
+
+// sqlCommentEscape URL encodes s as sqlcommenter requires, which also escapes quotes and comment delimiters.
+func sqlCommentEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
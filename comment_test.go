@@ -0,0 +1,96 @@
+package sqlb_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"go.senan.xyz/sqlb"
+)
+
+func ExampleWithSQLComment() {
+	ctx := context.Background()
+	db := newDB(ctx)
+	defer db.Close()
+
+	ctx = sqlb.WithLogEventFunc(ctx, func(ctx context.Context, ev sqlb.LogEvent) {
+		fmt.Println(ev.Query)
+	})
+	ctx = sqlb.WithSQLComment(ctx, "route", "/tasks/{id}")
+	ctx = sqlb.WithSQLComment(ctx, "app", "it's")
+	ctx = sqlb.WithSQLCommentCaller(ctx)
+
+	var task Task
+	_ = sqlb.QueryRow(ctx, db, &task, "SELECT * FROM tasks WHERE id = ?;", 1)
+	// Output:
+	// SELECT * FROM tasks WHERE id = ? /*app='it%27s',caller='sqlb_test.ExampleWithSQLComment',route='%2Ftasks%2F%7Bid%7D'*/;
+}
+
+func TestSQLCommentStmtCache(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+
+	var prepared []string
+	cache := sqlb.NewStmtCache(prepareWrap{db, func(ctx context.Context, query string) {
+		prepared = append(prepared, query)
+	}})
+	defer cache.Close()
+
+	var fingerprints []string
+	ctx = sqlb.WithLogEventFunc(ctx, func(ctx context.Context, ev sqlb.LogEvent) {
+		if ev.Type == "query" {
+			fingerprints = append(fingerprints, ev.Fingerprint)
+		}
+	})
+
+	for _, trace := range []string{"a", "b", "c"} {
+		ctx := sqlb.WithSQLComment(ctx, "trace", trace)
+		var x int
+		if err := sqlb.QueryRow(ctx, cache, sqlb.Scan(&x), "SELECT ?", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// comments written by the user are kept
+	var x int
+	if err := sqlb.QueryRow(ctx, cache, sqlb.Scan(&x), "SELECT 2 /*note='kept'*/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sqlb.QueryRow(sqlb.WithSQLComment(ctx, "trace", "d"), cache, sqlb.Scan(&x), "SELECT 3 /*note='kept'*/"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"SELECT ?", "SELECT 2 /*note='kept'*/", "SELECT 3 /*note='kept'*/"}
+	if fmt.Sprint(prepared) != fmt.Sprint(want) {
+		t.Errorf("got prepared %q, want %q", prepared, want)
+	}
+	if len(fingerprints) != 5 || fingerprints[0] != "select ?" || fingerprints[0] != fingerprints[2] {
+		t.Errorf("expected fingerprints to ignore comments, got %q", fingerprints)
+	}
+}
+
+func TestSQLCommentReplace(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+
+	var queries []string
+	ctx = sqlb.WithLogEventFunc(ctx, func(ctx context.Context, ev sqlb.LogEvent) {
+		queries = append(queries, ev.Query)
+	})
+
+	parent := sqlb.WithSQLComment(ctx, "k", "1")
+	child := sqlb.WithSQLComment(parent, "k", "2")
+
+	_ = sqlb.Exec(parent, db, "SELECT 1")
+	_ = sqlb.Exec(child, db, "SELECT 1")
+	_ = sqlb.Exec(ctx, db, "SELECT 1")
+
+	want := []string{"SELECT 1 /*k='1'*/", "SELECT 1 /*k='2'*/", "SELECT 1"}
+	if fmt.Sprint(queries) != fmt.Sprint(want) {
+		t.Errorf("got %q, want %q", queries, want)
+	}
+}
@@ -0,0 +1,71 @@
+package sqlb
+
+import (
+	"context"
+	"strconv"
+	"strings"
+)
+
+// Dialect identifies the SQL syntax of a database, for features that generate database-specific SQL.
+type Dialect int
+
+const (
+	DialectSQLite Dialect = iota
+	DialectPostgres
+	DialectMySQL
+)
+
+func (d Dialect) String() string {
+	switch d {
+	case DialectSQLite:
+		return "sqlite"
+	case DialectPostgres:
+		return "postgres"
+	case DialectMySQL:
+		return "mysql"
+	default:
+		return "unknown"
+	}
+}
+
+// bind rewrites ? placeholders to the dialect's syntax. It is only for sqlb's own queries, which have no ? in literals.
+func (d Dialect) bind(query string) string {
+	if d != DialectPostgres {
+		return query
+	}
+	var b strings.Builder
+	var n int
+	for _, c := range query {
+		if c != '?' {
+			b.WriteRune(c)
+			continue
+		}
+		n++
+		b.WriteString("$" + strconv.Itoa(n))
+	}
+	return b.String()
+}
+
+// tableExists reports whether the table name exists, which may be qualified with a schema.
+// Unqualified names are looked up in the current schema, or in any attached database for SQLite.
+func (d Dialect) tableExists(ctx context.Context, db QueryDB, name string) (bool, error) {
+	schema, table, ok := strings.Cut(name, ".")
+	if !ok {
+		schema, table = "", name
+	}
+
+	var query string
+	switch d {
+	case DialectPostgres:
+		query = "SELECT count(*) FROM information_schema.tables WHERE table_schema = coalesce(nullif(?, ''), current_schema()) AND table_name = ?"
+	case DialectMySQL:
+		query = "SELECT count(*) FROM information_schema.tables WHERE table_schema = coalesce(nullif(?, ''), database()) AND table_name = ?"
+	default:
+		query = "SELECT count(*) FROM pragma_table_list WHERE coalesce(nullif(?, ''), schema) = schema AND name = ? AND type = 'table'"
+	}
+	var n int
+	if err := QueryRow(ctx, db, Scan(&n), d.bind(query), schema, table); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
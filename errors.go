@@ -0,0 +1,248 @@
+package sqlb
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ErrorKind is a driver-agnostic classification of a database error.
+type ErrorKind int
+
+const (
+	ErrorUnknown ErrorKind = iota
+	ErrorUniqueViolation
+	ErrorForeignKeyViolation
+	ErrorNotNullViolation
+	ErrorCheckViolation
+	ErrorSerializationFailure
+	ErrorSchemaChanged
+	ErrorBusy
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrorUniqueViolation:
+		return "unique violation"
+	case ErrorForeignKeyViolation:
+		return "foreign key violation"
+	case ErrorNotNullViolation:
+		return "not null violation"
+	case ErrorCheckViolation:
+		return "check violation"
+	case ErrorSerializationFailure:
+		return "serialization failure"
+	case ErrorSchemaChanged:
+		return "schema changed"
+	case ErrorBusy:
+		return "busy"
+	default:
+		return "unknown"
+	}
+}
+
+// ErrorInfo describes a classified database error.
+type ErrorInfo struct {
+	Kind ErrorKind
+	// Constraint is the violated constraint or column name, if the driver reports one.
+	Constraint string
+}
+
+// ErrorClassifier classifies errors from a particular driver, returning false if err is not recognised.
+type ErrorClassifier func(err error) (ErrorInfo, bool)
+
+type namedClassifier struct {
+	name string
+	c    ErrorClassifier
+}
+
+var classifiers = struct {
+	mu   sync.RWMutex
+	list []namedClassifier
+}{
+	list: []namedClassifier{
+		{"sqlite", classifySQLite},
+		{"postgres", classifyPostgres},
+		{"mysql", classifyMySQL},
+	},
+}
+
+// RegisterErrorClassifier registers an [ErrorClassifier] under name, replacing any classifier
+// previously registered with the same name. Classifiers for "sqlite", "postgres", and "mysql" are built-in.
+func RegisterErrorClassifier(name string, c ErrorClassifier) {
+	classifiers.mu.Lock()
+	defer classifiers.mu.Unlock()
+
+	for i := range classifiers.list {
+		if classifiers.list[i].name == name {
+			classifiers.list[i].c = c
+			return
+		}
+	}
+	classifiers.list = append(classifiers.list, namedClassifier{name, c})
+}
+
+// ClassifyError classifies err using the registered classifiers, in registration order.
+// The Kind is [ErrorUnknown] if no classifier recognises err.
+func ClassifyError(err error) ErrorInfo {
+	if err == nil {
+		return ErrorInfo{}
+	}
+
+	classifiers.mu.RLock()
+	defer classifiers.mu.RUnlock()
+
+	for _, nc := range classifiers.list {
+		if info, ok := nc.c(err); ok {
+			return info
+		}
+	}
+	return ErrorInfo{}
+}
+
+// IsUniqueViolation reports whether err is a unique or primary key constraint violation.
+func IsUniqueViolation(err error) bool {
+	return ClassifyError(err).Kind == ErrorUniqueViolation
+}
+
+// IsForeignKeyViolation reports whether err is a foreign key constraint violation.
+func IsForeignKeyViolation(err error) bool {
+	return ClassifyError(err).Kind == ErrorForeignKeyViolation
+}
+
+// IsNotNullViolation reports whether err is a not null constraint violation.
+func IsNotNullViolation(err error) bool {
+	return ClassifyError(err).Kind == ErrorNotNullViolation
+}
+
+// IsCheckViolation reports whether err is a check constraint violation.
+func IsCheckViolation(err error) bool {
+	return ClassifyError(err).Kind == ErrorCheckViolation
+}
+
+// IsSerializationFailure reports whether err is a serialization failure that may succeed if the transaction is retried.
+func IsSerializationFailure(err error) bool {
+	return ClassifyError(err).Kind == ErrorSerializationFailure
+}
+
+// IsSchemaChanged reports whether err is from a prepared statement invalidated by a schema change, which may succeed if prepared again.
+func IsSchemaChanged(err error) bool {
+	return ClassifyError(err).Kind == ErrorSchemaChanged
+}
+
+// IsBusy reports whether err is from a locked database or row, which may succeed if retried.
+func IsBusy(err error) bool {
+	return ClassifyError(err).Kind == ErrorBusy
+}
+
+// ConstraintName returns the violated constraint or column name from err, or "" if unknown.
+func ConstraintName(err error) string {
+	return ClassifyError(err).Constraint
+}
+
+// classifySQLite matches on SQLite's error messages, which are the same across drivers.
+func classifySQLite(err error) (ErrorInfo, bool) {
+	msg := err.Error()
+	for _, p := range []struct {
+		prefix string
+		kind   ErrorKind
+	}{
+		{"UNIQUE constraint failed", ErrorUniqueViolation},
+		{"FOREIGN KEY constraint failed", ErrorForeignKeyViolation},
+		{"NOT NULL constraint failed", ErrorNotNullViolation},
+		{"CHECK constraint failed", ErrorCheckViolation},
+		{"database schema has changed", ErrorSchemaChanged},
+		{"database is locked", ErrorBusy},
+		{"database table is locked", ErrorBusy},
+	} {
+		_, rest, ok := strings.Cut(msg, p.prefix)
+		if !ok {
+			continue
+		}
+		return ErrorInfo{Kind: p.kind, Constraint: strings.TrimPrefix(rest, ": ")}, true
+	}
+	return ErrorInfo{}, false
+}
+
+// classifyPostgres matches on the SQLSTATE exposed by pgx and lib/pq errors.
+func classifyPostgres(err error) (ErrorInfo, bool) {
+	var se interface{ SQLState() string }
+	if !errors.As(err, &se) {
+		return ErrorInfo{}, false
+	}
+
+	var kind ErrorKind
+	switch se.SQLState() {
+	case "23505":
+		kind = ErrorUniqueViolation
+	case "23503":
+		kind = ErrorForeignKeyViolation
+	case "23502":
+		kind = ErrorNotNullViolation
+	case "23514":
+		kind = ErrorCheckViolation
+	case "40001", "40P01":
+		kind = ErrorSerializationFailure
+	case "55P03":
+		kind = ErrorBusy
+	case "0A000":
+		// feature_not_supported is broad, only the plan error means a schema change
+		if !strings.Contains(err.Error(), "cached plan must not change result type") {
+			return ErrorInfo{}, false
+		}
+		return ErrorInfo{Kind: ErrorSchemaChanged}, true
+	default:
+		return ErrorInfo{}, false
+	}
+
+	// e.g. `duplicate key value violates unique constraint "users_email_key"`
+	// or `null value in column "email" of relation "users" violates not-null constraint`
+	msg := err.Error()
+	marker := `constraint "`
+	if kind == ErrorNotNullViolation {
+		marker = `column "`
+	}
+	var constraint string
+	if _, rest, ok := strings.Cut(msg, marker); ok {
+		constraint, _, _ = strings.Cut(rest, `"`)
+	}
+	return ErrorInfo{Kind: kind, Constraint: constraint}, true
+}
+
+// e.g. "Error 1062 (23000): Duplicate entry 'a' for key 'users.email'" from go-sql-driver/mysql,
+// which has no method exposing the error number.
+var mysqlError = regexp.MustCompile(`\bError (\d{4})(?: \([0-9A-Z]{5}\))?: (.*)$`)
+
+// classifyMySQL matches on the error number in messages from go-sql-driver/mysql.
+func classifyMySQL(err error) (ErrorInfo, bool) {
+	match := mysqlError.FindStringSubmatch(err.Error())
+	if match == nil {
+		return ErrorInfo{}, false
+	}
+
+	var kind ErrorKind
+	var marker string
+	switch match[1] {
+	case "1062":
+		kind, marker = ErrorUniqueViolation, "for key '"
+	case "1451", "1452":
+		kind, marker = ErrorForeignKeyViolation, "CONSTRAINT `"
+	case "1048":
+		kind, marker = ErrorNotNullViolation, "Column '"
+	case "3819":
+		kind, marker = ErrorCheckViolation, "Check constraint '"
+	case "1213":
+		kind = ErrorSerializationFailure
+	case "1205":
+		kind = ErrorBusy
+	default:
+		return ErrorInfo{}, false
+	}
+
+	var constraint string
+	if _, rest, ok := strings.Cut(match[2], marker); ok && marker != "" {
+		constraint, _, _ = strings.Cut(rest, marker[len(marker)-1:])
+	}
+	return ErrorInfo{Kind: kind, Constraint: constraint}, true
+}
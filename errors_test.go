@@ -0,0 +1,162 @@
+package sqlb_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"go.senan.xyz/sqlb"
+)
+
+func ExampleIsUniqueViolation() {
+	ctx := context.Background()
+	db := newConstraintDB(ctx)
+	defer db.Close()
+
+	_ = sqlb.Exec(ctx, db, "INSERT INTO users (email, age) VALUES (?, ?)", "alice@example.com", 30)
+	err := sqlb.Exec(ctx, db, "INSERT INTO users (email, age) VALUES (?, ?)", "alice@example.com", 31)
+	if sqlb.IsUniqueViolation(err) {
+		fmt.Println("duplicate", sqlb.ConstraintName(err))
+	}
+	// Output:
+	// duplicate users.email
+}
+
+func TestClassifySQLite(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newConstraintDB(ctx)
+	defer db.Close()
+
+	if err := sqlb.Exec(ctx, db, "INSERT INTO users (email, age) VALUES (?, ?)", "a", 30); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		query      string
+		args       []any
+		kind       sqlb.ErrorKind
+		constraint string
+	}{
+		{"INSERT INTO users (email, age) VALUES (?, ?)", []any{"a", 30}, sqlb.ErrorUniqueViolation, "users.email"},
+		{"INSERT INTO users (age) VALUES (?)", []any{30}, sqlb.ErrorNotNullViolation, "users.email"},
+		{"INSERT INTO users (email, age) VALUES (?, ?)", []any{"b", -1}, sqlb.ErrorCheckViolation, "age_positive"},
+		{"INSERT INTO posts (user_id) VALUES (?)", []any{999}, sqlb.ErrorForeignKeyViolation, ""},
+		{"SELECT * FROM nonexistent", nil, sqlb.ErrorUnknown, ""},
+	}
+	for _, c := range cases {
+		err := sqlb.Exec(ctx, db, c.query, c.args...)
+		if err == nil {
+			t.Fatalf("%s: expected error", c.query)
+		}
+		info := sqlb.ClassifyError(fmt.Errorf("wrapped: %w", err))
+		if info.Kind != c.kind || info.Constraint != c.constraint {
+			t.Errorf("%s: got %v %q, want %v %q", c.query, info.Kind, info.Constraint, c.kind, c.constraint)
+		}
+	}
+}
+
+func TestClassifyPostgres(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		err        error
+		kind       sqlb.ErrorKind
+		constraint string
+	}{
+		{pgError{"23505", `duplicate key value violates unique constraint "users_email_key"`}, sqlb.ErrorUniqueViolation, "users_email_key"},
+		{pgError{"23502", `null value in column "email" of relation "users" violates not-null constraint`}, sqlb.ErrorNotNullViolation, "email"},
+		{pgError{"40001", `could not serialize access due to concurrent update`}, sqlb.ErrorSerializationFailure, ""},
+		{pgError{"55P03", `could not obtain lock on row in relation "users"`}, sqlb.ErrorBusy, ""},
+		{pgError{"0A000", `cached plan must not change result type`}, sqlb.ErrorSchemaChanged, ""},
+		{pgError{"0A000", `cannot use aggregate here`}, sqlb.ErrorUnknown, ""},
+		{pgError{"42P01", `relation "nonexistent" does not exist`}, sqlb.ErrorUnknown, ""},
+	}
+	for _, c := range cases {
+		info := sqlb.ClassifyError(c.err)
+		if info.Kind != c.kind || info.Constraint != c.constraint {
+			t.Errorf("%v: got %v %q, want %v %q", c.err, info.Kind, info.Constraint, c.kind, c.constraint)
+		}
+	}
+	if !sqlb.IsSerializationFailure(pgError{"40001", ""}) {
+		t.Error("expected serialization failure")
+	}
+}
+
+func TestClassifyMySQL(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		err        error
+		kind       sqlb.ErrorKind
+		constraint string
+	}{
+		{errors.New("Error 1062 (23000): Duplicate entry 'a' for key 'users.email'"), sqlb.ErrorUniqueViolation, "users.email"},
+		{errors.New("Error 1062: Duplicate entry 'a' for key 'email'"), sqlb.ErrorUniqueViolation, "email"},
+		{errors.New("Error 1452 (23000): Cannot add or update a child row: a foreign key constraint fails (`app`.`posts`, CONSTRAINT `posts_user_fk` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`))"), sqlb.ErrorForeignKeyViolation, "posts_user_fk"},
+		{errors.New("Error 1451 (23000): Cannot delete or update a parent row: a foreign key constraint fails (`app`.`posts`, CONSTRAINT `posts_user_fk` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`))"), sqlb.ErrorForeignKeyViolation, "posts_user_fk"},
+		{errors.New("Error 1048 (23000): Column 'email' cannot be null"), sqlb.ErrorNotNullViolation, "email"},
+		{errors.New("Error 3819 (HY000): Check constraint 'age_positive' is violated."), sqlb.ErrorCheckViolation, "age_positive"},
+		{errors.New("Error 1213 (40001): Deadlock found when trying to get lock; try restarting transaction"), sqlb.ErrorSerializationFailure, ""},
+		{errors.New("Error 1205 (HY000): Lock wait timeout exceeded; try restarting transaction"), sqlb.ErrorBusy, ""},
+		{errors.New("Error 1146 (42S02): Table 'app.nonexistent' doesn't exist"), sqlb.ErrorUnknown, ""},
+		{errors.New("Error reading config: no such file"), sqlb.ErrorUnknown, ""},
+	}
+	for _, c := range cases {
+		info := sqlb.ClassifyError(fmt.Errorf("wrapped: %w", c.err))
+		if info.Kind != c.kind || info.Constraint != c.constraint {
+			t.Errorf("%v: got %v %q, want %v %q", c.err, info.Kind, info.Constraint, c.kind, c.constraint)
+		}
+	}
+}
+
+func TestRegisterErrorClassifier(t *testing.T) {
+	t.Parallel()
+
+	type customError struct{ error }
+	sqlb.RegisterErrorClassifier("custom-test", func(err error) (sqlb.ErrorInfo, bool) {
+		var ce customError
+		if !errors.As(err, &ce) {
+			return sqlb.ErrorInfo{}, false
+		}
+		return sqlb.ErrorInfo{Kind: sqlb.ErrorCheckViolation, Constraint: "custom"}, true
+	})
+
+	err := customError{errors.New("boom")}
+	if !sqlb.IsCheckViolation(err) {
+		t.Error("expected check violation from custom classifier")
+	}
+	if got := sqlb.ConstraintName(err); got != "custom" {
+		t.Errorf("got constraint %q, want %q", got, "custom")
+	}
+	if sqlb.IsCheckViolation(errors.New("boom")) {
+		t.Error("unexpected check violation for plain error")
+	}
+}
+
+type pgError struct {
+	code, msg string
+}
+
+func (e pgError) Error() string    { return e.msg }
+func (e pgError) SQLState() string { return e.code }
+
+func newConstraintDB(ctx context.Context) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		panic(err)
+	}
+	db.SetMaxOpenConns(1)
+	if err := sqlb.Exec(ctx, db, `PRAGMA foreign_keys = ON`); err != nil {
+		panic(err)
+	}
+	if err := sqlb.Exec(ctx, db, `create table users (id integer primary key autoincrement, email text not null unique, age integer not null, constraint age_positive check (age > 0))`); err != nil {
+		panic(err)
+	}
+	if err := sqlb.Exec(ctx, db, `create table posts (id integer primary key autoincrement, user_id integer not null references users (id))`); err != nil {
+		panic(err)
+	}
+	return db
+}
@@ -0,0 +1,113 @@
+package sqlb
+
+import (
+	"regexp"
+	"strings"
+)
+
+// fingerprintList matches a list of placeholders after IN, or lists of them after VALUES, but not function arguments
+// like coalesce(?, ?), whose number of arguments changes the meaning of the query.
+var fingerprintList = regexp.MustCompile(`\b(in|values) ?\(\?(?:, \?)*\)(?:, \(\?(?:, \?)*\))*`)
+
+// Fingerprint returns a normalised form of query that is stable across its literal values, so queries with
+// the same shape can be aggregated. Comments are removed, string and number literals and placeholders are
+// replaced with ?, whitespace and case are normalised, and lists after IN or VALUES like those from [InSQL] and
+// [InsertSQL] are collapsed to (?+) regardless of their length:
+//
+//	SELECT * FROM users WHERE id IN (?, ?, ?) AND name = 'alice'  -- select * from users where id in (?+) and name = ?
+func Fingerprint(query string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+
+	var space bool
+	emit := func(tok string) {
+		if space && b.Len() > 0 && !strings.HasSuffix(b.String(), "(") && tok != ")" && tok != "," {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteString(tok)
+	}
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			i += end
+			space = true
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				end = len(query) - i - 4
+			}
+			i += end + 4
+			space = true
+		case isSpace(c):
+			i++
+			space = true
+		case c == '\'':
+			i = skipQuoted(query, i, '\'')
+			emit("?")
+		case c == '"' || c == '`':
+			end := skipQuoted(query, i, c)
+			emit(query[i:end])
+			i = end
+		case c == '$' && i+1 < len(query) && isDigit(query[i+1]):
+			i++
+			for i < len(query) && isDigit(query[i]) {
+				i++
+			}
+			emit("?")
+		case isDigit(c):
+			for i < len(query) && (isIdent(query[i]) || query[i] == '.') {
+				i++
+			}
+			emit("?")
+		case isIdent(c):
+			start := i
+			for i < len(query) && isIdent(query[i]) {
+				i++
+			}
+			emit(strings.ToLower(query[start:i]))
+		case c == ',':
+			i++
+			emit(",")
+			space = true
+		default:
+			i++
+			emit(string(c))
+		}
+	}
+
+	return fingerprintList.ReplaceAllString(b.String(), "${1} (?+)")
+}
+
+// skipQuoted returns the index after the quoted string starting at i, treating a doubled quote as an escape.
+func skipQuoted(s string, i int, quote byte) int {
+	for i++; i < len(s); i++ {
+		if s[i] != quote {
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == quote {
+			i++
+			continue
+		}
+		return i + 1
+	}
+	return len(s)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdent(c byte) bool {
+	return c == '_' || c == '$' || isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
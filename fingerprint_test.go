@@ -0,0 +1,67 @@
+package sqlb_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"go.senan.xyz/sqlb"
+)
+
+func ExampleFingerprint() {
+	a, _ := sqlb.NewQuery("SELECT * FROM users WHERE id IN ?", sqlb.InSQL(1, 2, 3)).SQL()
+	b, _ := sqlb.NewQuery("SELECT * FROM users WHERE id IN ?", sqlb.InSQL(4)).SQL()
+	fmt.Println(sqlb.Fingerprint(a))
+	fmt.Println(sqlb.Fingerprint(a) == sqlb.Fingerprint(b))
+	// Output:
+	// select * from users where id in (?+)
+	// true
+}
+
+func TestFingerprint(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		in, want string
+	}{
+		{"SELECT 1", "select ?"},
+		{"select  *\n\tFROM users WHERE name = 'o''brien' AND age > 18.5", "select * from users where name = ? and age > ?"},
+		{"SELECT * FROM users -- trailing\nWHERE id = $1 /* block\ncomment */ LIMIT 10", "select * from users where id = ? limit ?"},
+		{`SELECT "User Name", t1.id FROM "Users" t1`, `select "User Name", t1.id from "Users" t1`},
+		{"SELECT * FROM t WHERE id IN ( ?,?  , ? ) AND x IN (?)", "select * from t where id in (?+) and x in (?+)"},
+		{"INSERT INTO tasks (name, age) VALUES (?, ?), (?, ?), (?, ?)", "insert into tasks (name, age) values (?+)"},
+		{"INSERT INTO tasks (name, age) VALUES (?, ?)", "insert into tasks (name, age) values (?+)"},
+		{"SELECT * FROM t WHERE id IN (SELECT id FROM u WHERE x = 1)", "select * from t where id in (select id from u where x = ?)"},
+		{"SELECT * FROM t WHERE id NOT IN(?, ?)", "select * from t where id not in (?+)"},
+		{"SELECT coalesce(?, ?), lower(?) FROM t WHERE (a, b) = (?, ?)", "select coalesce(?, ?), lower(?) from t where (a, b) = (?, ?)"},
+		{"SELECT * FROM t WHERE name = lower(?) AND id IN (?)", "select * from t where name = lower(?) and id in (?+)"},
+		{"UPDATE t SET x = coalesce(?, ?, ?)", "update t set x = coalesce(?, ?, ?)"},
+		{"SELECT 0x1F, 1e10", "select ?, ?"},
+		{"SELECT 'unterminated", "select ?"},
+		{"", ""},
+	}
+	for _, c := range cases {
+		if got := sqlb.Fingerprint(c.in); got != c.want {
+			t.Errorf("Fingerprint(%q)\ngot  %q\nwant %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestFingerprintLogEvent(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+
+	var fingerprints []string
+	ctx = sqlb.WithLogEventFunc(ctx, func(ctx context.Context, ev sqlb.LogEvent) {
+		fingerprints = append(fingerprints, ev.Fingerprint)
+	})
+
+	_ = sqlb.Exec(ctx, db, "INSERT INTO tasks ?", sqlb.InsertSQL(Task{Name: "a"}))
+	_ = sqlb.Exec(ctx, db, "INSERT INTO tasks ?", sqlb.InsertSQL(Task{Name: "b"}, Task{Name: "c"}))
+
+	if len(fingerprints) != 2 || fingerprints[0] != fingerprints[1] {
+		t.Errorf("expected matching fingerprints, got %q", fingerprints)
+	}
+}
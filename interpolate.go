@@ -0,0 +1,101 @@
+package sqlb
+
+import (
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UnsafeInterpolate renders q with its arguments inlined as literals for the dialect, to paste into
+// a database shell when debugging. The result is NOT safe to execute: quoting is best-effort and
+// must never be relied on to prevent SQL injection. [Sensitive] values are rendered as '<redacted>'.
+func UnsafeInterpolate(d Dialect, q SQLer) string {
+	query, args := q.SQL()
+
+	var b strings.Builder
+	var count int
+	for _, c := range query {
+		if c != '?' || count >= len(args) {
+			b.WriteRune(c)
+			continue
+		}
+		b.WriteString(literal(d, args[count]))
+		count++
+	}
+	return b.String()
+}
+
+func literal(d Dialect, v any) string {
+	// nil pointers, including to Valuers with value receivers which would panic, are NULL like the driver sends them
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Pointer && rv.IsNil() {
+		return "NULL"
+	}
+
+	switch v := v.(type) {
+	case sensitive:
+		return quote(d, redacted)
+	case jsonValuer:
+		b, err := v.Value()
+		if err != nil {
+			return quote(d, fmt.Sprintf("<error: %v>", err))
+		}
+		if b, ok := b.([]byte); ok {
+			return quote(d, string(b))
+		}
+		return literal(d, b)
+	case driver.Valuer:
+		dv, err := v.Value()
+		if err != nil {
+			return quote(d, fmt.Sprintf("<error: %v>", err))
+		}
+		if _, ok := dv.(driver.Valuer); ok {
+			return quote(d, fmt.Sprint(dv))
+		}
+		return literal(d, dv)
+	case nil:
+		return "NULL"
+	case string:
+		return quote(d, v)
+	case []byte:
+		if v == nil {
+			return "NULL"
+		}
+		if d == DialectPostgres {
+			return `'\x` + hex.EncodeToString(v) + `'::bytea`
+		}
+		return "X'" + hex.EncodeToString(v) + "'"
+	case bool:
+		if v {
+			return "TRUE"
+		}
+		return "FALSE"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case time.Time:
+		if d == DialectMySQL {
+			return quote(d, v.Format("2006-01-02 15:04:05.999999"))
+		}
+		return quote(d, v.Format(time.RFC3339Nano))
+	}
+
+	// other ints, floats, and named types
+	dv, err := driver.DefaultParameterConverter.ConvertValue(v)
+	if err != nil {
+		return quote(d, fmt.Sprint(v))
+	}
+	return literal(d, dv)
+}
+
+func quote(d Dialect, s string) string {
+	s = strings.ReplaceAll(s, "'", "''")
+	if d == DialectMySQL {
+		s = strings.ReplaceAll(s, `\`, `\\`)
+	}
+	return "'" + s + "'"
+}
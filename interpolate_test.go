@@ -0,0 +1,83 @@
+package sqlb_test
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.senan.xyz/sqlb"
+)
+
+func ExampleUnsafeInterpolate() {
+	var q sqlb.Query
+	q.Append("SELECT * FROM users WHERE name = ?", "o'brien")
+	q.Append("AND id IN ?", sqlb.InSQL(1, 2, 3))
+	q.Append("AND token = ?", sqlb.NewSensitive("hunter2"))
+
+	fmt.Println(sqlb.UnsafeInterpolate(sqlb.DialectSQLite, q))
+	// Output:
+	// SELECT * FROM users WHERE name = 'o''brien' AND id IN (1, 2, 3) AND token = '<redacted>'
+}
+
+func TestUnsafeInterpolate(t *testing.T) {
+	t.Parallel()
+
+	when := time.Date(2024, 1, 2, 3, 4, 5, 600_000_000, time.UTC)
+	type myInt int
+
+	cases := []struct {
+		dialect sqlb.Dialect
+		arg     any
+		want    string
+	}{
+		{sqlb.DialectSQLite, nil, "NULL"},
+		{sqlb.DialectSQLite, "a'b", "'a''b'"},
+		{sqlb.DialectMySQL, `a\'b`, `'a\\''b'`},
+		{sqlb.DialectPostgres, `a\b`, `'a\b'`},
+		{sqlb.DialectSQLite, []byte{0xde, 0xad}, "X'dead'"},
+		{sqlb.DialectPostgres, []byte{0xde, 0xad}, `'\xdead'::bytea`},
+		{sqlb.DialectSQLite, true, "TRUE"},
+		{sqlb.DialectSQLite, 42, "42"},
+		{sqlb.DialectSQLite, myInt(7), "7"},
+		{sqlb.DialectSQLite, uint8(8), "8"},
+		{sqlb.DialectSQLite, 1.5, "1.5"},
+		{sqlb.DialectSQLite, float32(0.25), "0.25"},
+		{sqlb.DialectSQLite, when, "'2024-01-02T03:04:05.6Z'"},
+		{sqlb.DialectMySQL, when, "'2024-01-02 03:04:05.6'"},
+		{sqlb.DialectSQLite, sql.NullString{}, "NULL"},
+		{sqlb.DialectSQLite, sql.NullInt64{Int64: 3, Valid: true}, "3"},
+		{sqlb.DialectSQLite, (*sql.NullString)(nil), "NULL"},
+		{sqlb.DialectSQLite, &sql.NullInt64{Int64: 3, Valid: true}, "3"},
+		{sqlb.DialectSQLite, (*int)(nil), "NULL"},
+		{sqlb.DialectSQLite, sqlb.NewJSON(map[string]string{"k": "it's"}), `'{"k":"it''s"}'`},
+		{sqlb.DialectSQLite, (*sqlb.JSON[int])(nil), "NULL"},
+		{sqlb.DialectSQLite, sqlb.NewSensitive(42), "'<redacted>'"},
+		{sqlb.DialectSQLite, struct{ A int }{1}, "'{1}'"},
+	}
+	for _, c := range cases {
+		got := sqlb.UnsafeInterpolate(c.dialect, sqlb.NewQuery("?", c.arg))
+		if got != c.want {
+			t.Errorf("%v %#v: got %s, want %s", c.dialect, c.arg, got, c.want)
+		}
+	}
+}
+
+func TestUnsafeInterpolateRoundTrip(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+
+	q := sqlb.NewQuery("SELECT ?, ?, ?, hex(?)", "it's", 42, nil, []byte("hi"))
+
+	var s, hex string
+	var n int
+	var null sql.NullString
+	if err := sqlb.QueryRow(ctx, db, sqlb.Scan(&s, &n, &null, &hex), sqlb.UnsafeInterpolate(sqlb.DialectSQLite, q)); err != nil {
+		t.Fatal(err)
+	}
+	if s != "it's" || n != 42 || null.Valid || hex != "6869" {
+		t.Errorf("unexpected values %q %d %v %q", s, n, null, hex)
+	}
+}
@@ -0,0 +1,147 @@
+package sqlb
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"expvar"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyBuckets are the latency histogram bounds used by [NewMetrics] when none are given.
+var DefaultLatencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// Metrics aggregates counts, errors, rows, and latency histograms per operation type and per query [Fingerprint].
+// [sql.ErrNoRows] from [QueryRow] is not counted as an error.
+// Enable it with [WithMetrics], and read it with [Metrics.Snapshot] or publish it with [Metrics.Publish].
+type Metrics struct {
+	buckets []time.Duration
+
+	mu    sync.Mutex
+	stats map[metricsKey]*QueryStats
+}
+
+type metricsKey struct {
+	typ, fingerprint string
+}
+
+// QueryStats are the aggregated metrics for an operation type, and optionally a query fingerprint.
+type QueryStats struct {
+	Type        string        `json:"type"`
+	Fingerprint string        `json:"fingerprint,omitempty"` // empty for totals across all fingerprints of Type
+	Count       int64         `json:"count"`
+	Errors      int64         `json:"errors"`
+	Rows        int64         `json:"rows"`
+	Total       time.Duration `json:"total"`
+	// Latency counts operations by duration, where Latency[i] counts those no longer than Buckets[i],
+	// and the final element counts those longer than every bucket.
+	Latency []int64 `json:"latency"`
+}
+
+// MetricsSnapshot is a point-in-time copy of [Metrics].
+type MetricsSnapshot struct {
+	Buckets []time.Duration `json:"buckets"`
+	Types   []QueryStats    `json:"types"`   // totals per operation type
+	Queries []QueryStats    `json:"queries"` // per operation type and fingerprint
+}
+
+// NewMetrics creates a [Metrics] with the given latency histogram bounds, or [DefaultLatencyBuckets] if none are given.
+func NewMetrics(buckets ...time.Duration) *Metrics {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+	return &Metrics{
+		buckets: buckets,
+		stats:   make(map[metricsKey]*QueryStats),
+	}
+}
+
+type metricsContextKey struct{}
+
+// WithMetrics returns a context that will record queries in m.
+func WithMetrics(ctx context.Context, m *Metrics) context.Context {
+	return context.WithValue(ctx, metricsContextKey{}, m)
+}
+
+func metrics(ctx context.Context) *Metrics {
+	m, _ := ctx.Value(metricsContextKey{}).(*Metrics)
+	return m
+}
+
+// Snapshot returns a copy of the current metrics, sorted by type and fingerprint.
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snap := MetricsSnapshot{Buckets: slices.Clone(m.buckets)}
+	for k, qs := range m.stats {
+		qs := *qs
+		qs.Latency = slices.Clone(qs.Latency)
+		if k.fingerprint == "" {
+			snap.Types = append(snap.Types, qs)
+		} else {
+			snap.Queries = append(snap.Queries, qs)
+		}
+	}
+
+	compare := func(a, b QueryStats) int {
+		return cmp.Or(cmp.Compare(a.Type, b.Type), cmp.Compare(a.Fingerprint, b.Fingerprint))
+	}
+	slices.SortFunc(snap.Types, compare)
+	slices.SortFunc(snap.Queries, compare)
+	return snap
+}
+
+// Reset clears all recorded metrics.
+func (m *Metrics) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clear(m.stats)
+}
+
+// Publish exports the metrics snapshot as an [expvar] variable with the given name.
+// Like [expvar.Publish], it panics if the name is already registered.
+func (m *Metrics) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() any {
+		return m.Snapshot()
+	}))
+}
+
+func (m *Metrics) observe(ev LogEvent) {
+	bucket, _ := slices.BinarySearch(m.buckets, ev.Dur)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, k := range []metricsKey{{ev.Type, ""}, {ev.Type, ev.Fingerprint}} {
+		qs, ok := m.stats[k]
+		if !ok {
+			qs = &QueryStats{Type: k.typ, Fingerprint: k.fingerprint, Latency: make([]int64, len(m.buckets)+1)}
+			m.stats[k] = qs
+		}
+		qs.Count++
+		if ev.Err != nil && !errors.Is(ev.Err, sql.ErrNoRows) {
+			qs.Errors++
+		}
+		if ev.Rows > 0 {
+			qs.Rows += ev.Rows
+		}
+		qs.Total += ev.Dur
+		qs.Latency[bucket]++
+	}
+}
@@ -0,0 +1,116 @@
+package sqlb_test
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.senan.xyz/sqlb"
+)
+
+func ExampleMetrics() {
+	ctx := context.Background()
+	db := newDB(ctx)
+	defer db.Close()
+
+	m := sqlb.NewMetrics()
+	ctx = sqlb.WithMetrics(ctx, m)
+
+	_ = sqlb.Exec(ctx, db, "INSERT INTO tasks ?", sqlb.InsertSQL(Task{Name: "alice"}, Task{Name: "bob"}))
+	for _, name := range []string{"alice", "bob", "carol"} {
+		var task Task
+		_ = sqlb.QueryRow(ctx, db, &task, "SELECT * FROM tasks WHERE name = ?", name)
+	}
+
+	for _, qs := range m.Snapshot().Queries {
+		fmt.Printf("%s %q count=%d errors=%d rows=%d\n", qs.Type, qs.Fingerprint, qs.Count, qs.Errors, qs.Rows)
+	}
+	// Output:
+	// exec "insert into tasks (name, age) values (?+)" count=1 errors=0 rows=2
+	// query "select * from tasks where name = ?" count=3 errors=0 rows=2
+}
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+
+	cache := sqlb.NewStmtCache(db)
+	defer cache.Close()
+
+	m := sqlb.NewMetrics(time.Hour, time.Nanosecond)
+	ctx = sqlb.WithMetrics(ctx, m)
+
+	var x int
+	_ = sqlb.QueryRow(ctx, cache, sqlb.Scan(&x), "SELECT 1")
+	_ = sqlb.QueryRow(ctx, cache, sqlb.Scan(&x), "SELECT 2")
+	_ = sqlb.Exec(ctx, cache, "INVALID")
+
+	snap := m.Snapshot()
+	if len(snap.Buckets) != 2 || snap.Buckets[0] != time.Nanosecond {
+		t.Errorf("expected sorted buckets, got %v", snap.Buckets)
+	}
+
+	types := map[string]sqlb.QueryStats{}
+	for _, qs := range snap.Types {
+		types[qs.Type] = qs
+	}
+	if qs := types["query"]; qs.Count != 2 || qs.Errors != 0 || qs.Rows != 2 || qs.Latency[1] != 2 {
+		t.Errorf("unexpected query stats: %+v", qs)
+	}
+	if qs := types["prepare"]; qs.Count != 3 || qs.Errors != 1 {
+		t.Errorf("unexpected prepare stats: %+v", qs)
+	}
+	if qs := types["exec"]; qs.Count != 1 || qs.Errors != 1 {
+		t.Errorf("unexpected exec stats: %+v", qs)
+	}
+
+	// SELECT 1 and SELECT 2 share a fingerprint
+	var queries int
+	for _, qs := range snap.Queries {
+		if qs.Type == "query" {
+			queries++
+			if qs.Count != 2 {
+				t.Errorf("unexpected fingerprint stats: %+v", qs)
+			}
+		}
+	}
+	if queries != 1 {
+		t.Errorf("got %d query fingerprints, want 1", queries)
+	}
+
+	m.Reset()
+	if snap := m.Snapshot(); len(snap.Types) != 0 || len(snap.Queries) != 0 {
+		t.Errorf("expected empty snapshot after reset, got %+v", snap)
+	}
+}
+
+var publishRuns atomic.Int64
+
+func TestMetricsPublish(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+
+	// expvar names can't be reused, so each run of the test needs its own
+	name := fmt.Sprintf("sqlb-test-metrics-%d", publishRuns.Add(1))
+	m := sqlb.NewMetrics()
+	m.Publish(name)
+	ctx = sqlb.WithMetrics(ctx, m)
+
+	_ = sqlb.Exec(ctx, db, "SELECT 1")
+
+	var snap sqlb.MetricsSnapshot
+	if err := json.Unmarshal([]byte(expvar.Get(name).String()), &snap); err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Types) != 1 || snap.Types[0].Type != "exec" || snap.Types[0].Count != 1 {
+		t.Errorf("unexpected published snapshot: %+v", snap)
+	}
+}
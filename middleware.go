@@ -0,0 +1,172 @@
+package sqlb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Op describes a database operation passing through a [Middleware] chain.
+type Op struct {
+	Type  string // "query", "exec", or "prepare"
+	Query string
+	Args  []any
+
+	finish *[]func(err error) // shared by copies of the Op
+}
+
+// OnFinish registers fn to run once the operation has finished, with its error, so middleware can observe
+// the whole operation or release resources held for it. Functions run in reverse order of registration.
+//
+// For queries, that is once the rows have been closed by sqlb's helpers such as [QueryRows], with any error
+// from reading them other than [sql.ErrNoRows]. For queries run with QueryContext directly, the rows can't be
+// observed, so fn runs with a nil error once the query's context is done.
+func (op *Op) OnFinish(fn func(err error)) {
+	*op.finish = append(*op.finish, fn)
+}
+
+// Next calls the next [Middleware] in the chain, or the wrapped handle if it is the last.
+type Next = func(ctx context.Context, op *Op) error
+
+// Middleware wraps a database operation. It may modify ctx or op before calling next, observe the error
+// returned by next, or short-circuit by returning an error without calling next.
+//
+// For queries, next returns once rows are available. Use [Op.OnFinish] to observe errors from reading the rows,
+// or to cancel a context passed to next once the rows are closed, as [Timeout] does.
+type Middleware func(ctx context.Context, op *Op, next Next) error
+
+// Timeout returns a [Middleware] that limits each operation to d, including reading the rows of queries.
+func Timeout(d time.Duration) Middleware {
+	return func(ctx context.Context, op *Op, next Next) error {
+		ctx, cancel := context.WithTimeout(ctx, d)
+		op.OnFinish(func(error) { cancel() })
+		return next(ctx, op)
+	}
+}
+
+// MiddlewareDB is a handle that runs each operation through a [Middleware] chain. See [Wrap].
+type MiddlewareDB struct {
+	db QueryDB
+	mw []Middleware
+}
+
+// Wrap returns a handle that runs each operation on db through mw, with the first middleware outermost.
+//
+// db is typically a [*sql.DB], [*sql.Tx], [*sql.Conn], or [*StmtCache]. To apply middleware inside a transaction,
+// wrap the [*sql.Tx]. To have statement preparation pass through the middleware, wrap the handle given to [NewStmtCache].
+func Wrap(db QueryDB, mw ...Middleware) *MiddlewareDB {
+	return &MiddlewareDB{db: db, mw: mw}
+}
+
+func (m *MiddlewareDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	var rows *sql.Rows
+	op := newOp("query", query, args)
+	err := m.run(ctx, op, func(ctx context.Context, op *Op) error {
+		var err error
+		rows, err = m.db.QueryContext(ctx, op.Query, op.Args...) //nolint:sqlclosecheck // closed by caller
+		return err
+	})
+	if err != nil {
+		if rows != nil {
+			_ = rows.Close()
+		}
+		op.finished(err)
+		return nil, err
+	}
+	if rd, _ := ctx.Value(rowsDoneContextKey{}).(*rowsDone); rd != nil {
+		rd.fns = append(rd.fns, op.finished)
+	} else if len(*op.finish) > 0 {
+		context.AfterFunc(ctx, func() { op.finished(nil) })
+	}
+	return rows, nil
+}
+
+func (m *MiddlewareDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	db, ok := m.db.(ExecDB)
+	if !ok {
+		return nil, fmt.Errorf("%T does not support ExecContext", m.db)
+	}
+
+	var res sql.Result
+	op := newOp("exec", query, args)
+	err := m.run(ctx, op, func(ctx context.Context, op *Op) error {
+		var err error
+		res, err = db.ExecContext(ctx, op.Query, op.Args...)
+		return err
+	})
+	op.finished(err)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (m *MiddlewareDB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	db, ok := m.db.(PrepareDB)
+	if !ok {
+		return nil, fmt.Errorf("%T does not support PrepareContext", m.db)
+	}
+
+	var stmt *sql.Stmt
+	op := newOp("prepare", query, nil)
+	err := m.run(ctx, op, func(ctx context.Context, op *Op) error {
+		var err error
+		stmt, err = db.PrepareContext(ctx, op.Query) //nolint:sqlclosecheck // closed by caller
+		return err
+	})
+	op.finished(err)
+	if err != nil {
+		if stmt != nil {
+			_ = stmt.Close()
+		}
+		return nil, err
+	}
+	return stmt, nil
+}
+
+func newOp(typ, query string, args []any) *Op {
+	return &Op{Type: typ, Query: query, Args: args, finish: new([]func(error))}
+}
+
+func (op *Op) finished(err error) {
+	fns := *op.finish
+	*op.finish = nil
+	for i := len(fns) - 1; i >= 0; i-- {
+		fns[i](err)
+	}
+}
+
+func (m *MiddlewareDB) run(ctx context.Context, op *Op, last Next) error {
+	next := last
+	for i := len(m.mw) - 1; i >= 0; i-- {
+		mw, inner := m.mw[i], next
+		next = func(ctx context.Context, op *Op) error {
+			return mw(ctx, op, inner)
+		}
+	}
+	return next(ctx, op)
+}
+
+type rowsDoneContextKey struct{}
+
+// rowsDone collects the [Op.OnFinish] functions of queries made by sqlb's helpers, to run once they close the rows.
+type rowsDone struct {
+	fns []func(error)
+}
+
+func withRowsDone(ctx context.Context) (context.Context, *rowsDone) {
+	rd := &rowsDone{}
+	return context.WithValue(ctx, rowsDoneContextKey{}, rd), rd
+}
+
+func (rd *rowsDone) finish(err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+	}
+	for i := len(rd.fns) - 1; i >= 0; i-- {
+		rd.fns[i](err)
+	}
+	rd.fns = nil
+}
@@ -0,0 +1,245 @@
+package sqlb_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"go.senan.xyz/sqlb"
+)
+
+func ExampleWrap() {
+	ctx := context.Background()
+	db := newDB(ctx)
+	defer db.Close()
+
+	audit := func(ctx context.Context, op *sqlb.Op, next sqlb.Next) error {
+		err := next(ctx, op)
+		fmt.Printf("%s %q %v err=%v\n", op.Type, op.Query, op.Args, err)
+		return err
+	}
+	wdb := sqlb.Wrap(db, audit)
+
+	_ = sqlb.Exec(ctx, wdb, "INSERT INTO tasks (name) VALUES (?)", "alice")
+
+	var name string
+	_ = sqlb.QueryRow(ctx, wdb, sqlb.Scan(&name), "SELECT name FROM tasks")
+	// Output:
+	// exec "INSERT INTO tasks (name) VALUES (?)" [alice] err=<nil>
+	// query "SELECT name FROM tasks" [] err=<nil>
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+
+	var calls []string
+	named := func(name string) sqlb.Middleware {
+		return func(ctx context.Context, op *sqlb.Op, next sqlb.Next) error {
+			calls = append(calls, name+" before")
+			err := next(ctx, op)
+			calls = append(calls, name+" after")
+			return err
+		}
+	}
+
+	wdb := sqlb.Wrap(db, named("a"), named("b"))
+	if err := sqlb.Exec(ctx, wdb, "SELECT 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"a before", "b before", "b after", "a after"}
+	if fmt.Sprint(calls) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", calls, want)
+	}
+}
+
+func TestMiddlewareModify(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+
+	// only allow reading tasks older than 18
+	wdb := sqlb.Wrap(db, func(ctx context.Context, op *sqlb.Op, next sqlb.Next) error {
+		if op.Type == "query" {
+			op.Query = "SELECT * FROM (" + op.Query + ") WHERE age > ?"
+			op.Args = append(op.Args, 18)
+		}
+		return next(ctx, op)
+	})
+
+	_ = sqlb.Exec(ctx, wdb, "INSERT INTO tasks ?", sqlb.InsertSQL(Task{Name: "a", Age: 10}, Task{Name: "b", Age: 20}))
+
+	var tasks []Task
+	if err := sqlb.QueryRows(ctx, wdb, sqlb.Append(&tasks), "SELECT * FROM tasks"); err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 || tasks[0].Name != "b" {
+		t.Errorf("unexpected tasks: %+v", tasks)
+	}
+}
+
+func TestMiddlewareShortCircuit(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+
+	errReadOnly := errors.New("read only")
+	wdb := sqlb.Wrap(db, func(ctx context.Context, op *sqlb.Op, next sqlb.Next) error {
+		if op.Type == "exec" {
+			return errReadOnly
+		}
+		return next(ctx, op)
+	})
+
+	if err := sqlb.Exec(ctx, wdb, "INSERT INTO tasks (name) VALUES (?)", "alice"); !errors.Is(err, errReadOnly) {
+		t.Errorf("got %v, want %v", err, errReadOnly)
+	}
+
+	var count int
+	if err := sqlb.QueryRow(ctx, wdb, sqlb.Scan(&count), "SELECT count(*) FROM tasks"); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("got %d tasks, want 0", count)
+	}
+}
+
+func TestMiddlewareStmtCache(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+
+	var ops []string
+	record := func(ctx context.Context, op *sqlb.Op, next sqlb.Next) error {
+		ops = append(ops, op.Type)
+		return next(ctx, op)
+	}
+
+	cache := sqlb.NewStmtCache(sqlb.Wrap(db, record))
+	defer cache.Close()
+
+	wdb := sqlb.Wrap(cache, record)
+
+	var x int
+	for range 2 {
+		if err := sqlb.QueryRow(ctx, wdb, sqlb.Scan(&x), "SELECT 1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := strings.Join(ops, " "); got != "query prepare query" {
+		t.Errorf("got ops %q", got)
+	}
+}
+
+func TestMiddlewareTx(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+
+	var ops int
+	record := func(ctx context.Context, op *sqlb.Op, next sqlb.Next) error {
+		ops++
+		return next(ctx, op)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	wtx := sqlb.Wrap(tx, record)
+	if err := sqlb.Exec(ctx, wtx, "INSERT INTO tasks (name) VALUES (?)", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := wtx.PrepareContext(ctx, "SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt.Close()
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if ops != 2 {
+		t.Errorf("got %d ops, want 2", ops)
+	}
+}
+
+func TestMiddlewareOnFinish(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+
+	var got []string
+	record := func(ctx context.Context, op *sqlb.Op, next sqlb.Next) error {
+		op.OnFinish(func(err error) {
+			got = append(got, fmt.Sprintf("%s %v", op.Type, err))
+		})
+		return next(ctx, op)
+	}
+	wdb := sqlb.Wrap(db, record)
+
+	if err := sqlb.Exec(ctx, wdb, "INSERT INTO tasks (name) VALUES (?), (?)", "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	if err := sqlb.QueryRows(ctx, wdb, sqlb.AppendValue(&names), "SELECT name FROM tasks"); err != nil {
+		t.Fatal(err)
+	}
+	var name string
+	if err := sqlb.QueryRow(ctx, wdb, sqlb.Scan(&name), "SELECT name FROM tasks WHERE name = ?", "c"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("got %v, want %v", err, sql.ErrNoRows)
+	}
+	// fails while reading rows, rather than from QueryContext
+	var v string
+	if err := sqlb.QueryRow(ctx, wdb, sqlb.Scan(&v), "SELECT json('{')"); err == nil {
+		t.Error("expected error reading rows")
+	}
+
+	want := []string{"exec <nil>", "query <nil>", "query <nil>", "query sqlite3: SQL logic error: malformed JSON"}
+	if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMiddlewareTimeout(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+
+	wdb := sqlb.Wrap(db, sqlb.Timeout(time.Minute))
+	if err := sqlb.Exec(ctx, wdb, "INSERT INTO tasks (name) VALUES (?), (?), (?)", "a", "b", "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the timeout's context stays valid while reading the rows
+	for range 3 {
+		var names []string
+		if err := sqlb.QueryRows(ctx, wdb, sqlb.AppendValue(&names), "SELECT name FROM tasks"); err != nil {
+			t.Fatal(err)
+		}
+		if len(names) != 3 {
+			t.Errorf("got %d names, want 3", len(names))
+		}
+	}
+
+	short := sqlb.Wrap(db, sqlb.Timeout(time.Nanosecond))
+	time.Sleep(time.Millisecond)
+	if err := sqlb.Exec(ctx, short, "SELECT 1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,376 @@
+package sqlb
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// ErrMigrationChanged is returned by [Migrator] when an applied migration's checksum no longer matches its source.
+var ErrMigrationChanged = errors.New("applied migration has changed")
+
+// ErrMigrationLockLost is returned by [Migrator] when another instance took over the lock as stale while migrating.
+var ErrMigrationLockLost = errors.New("migrations lock lost")
+
+// MigrateDB is an interface compatible with [*sql.DB] for running migrations.
+type MigrateDB interface {
+	DB
+	TxDB
+}
+
+// Migration is a schema change with a version, ordered by version. It runs either SQL or Func.
+type Migration struct {
+	Version  int64
+	Name     string
+	Checksum string // hex SHA-256 of the SQL, empty for Func migrations
+	SQL      string
+	Func     func(ctx context.Context, tx *Tx) error
+}
+
+// MigrationStatus describes a migration known from its source or the migrations table, see [Migrator.Status].
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	Changed   bool // applied with a different checksum, see [ErrMigrationChanged]
+	Missing   bool // applied, but no longer in the source
+}
+
+// MigratorOptions configures a [Migrator].
+type MigratorOptions struct {
+	// Dialect is used for the migrations table queries. The default is [DialectSQLite].
+	Dialect Dialect
+	// Table is the name of the table tracking applied migrations. The default is sqlb_migrations.
+	// A table with the suffix _lock is also created, to lock the database while migrating.
+	Table string
+	// LockTimeout is how long the lock is held before others consider it stale, in case its holder stopped without
+	// releasing it. The holder extends it in each migration's transaction, and every third of the timeout from another
+	// connection while migrating. If the lock is lost anyway, migrating stops with [ErrMigrationLockLost].
+	// The default is 15 minutes.
+	LockTimeout time.Duration
+}
+
+// Migrator applies migrations to a database, tracking applied versions and checksums in a table.
+type Migrator struct {
+	db          MigrateDB
+	dialect     Dialect
+	table       string
+	lockTimeout time.Duration
+	migrations  []Migration
+}
+
+// lockPoll is how often a [Migrator] tries to take the lock while another instance holds it.
+const lockPoll = 100 * time.Millisecond
+
+var migrationFile = regexp.MustCompile(`^(\d+)_(.+)\.sql$`)
+
+// NewMigrator returns a [Migrator] for the .sql files in the root of fsys, which is typically an [embed.FS]
+// narrowed with [fs.Sub]. Files are named with their version, then an underscore and a name, like 0001_create_users.sql.
+// opts may be nil for the defaults.
+func NewMigrator(db MigrateDB, fsys fs.FS, opts *MigratorOptions) (*Migrator, error) {
+	if opts == nil {
+		opts = &MigratorOptions{}
+	}
+	m := &Migrator{
+		db:          db,
+		dialect:     opts.Dialect,
+		table:       cmp.Or(opts.Table, "sqlb_migrations"),
+		lockTimeout: cmp.Or(opts.LockTimeout, 15*time.Minute),
+	}
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("read migrations: %w", err)
+	}
+	for _, e := range entries {
+		if e.IsDir() || path.Ext(e.Name()) != ".sql" {
+			continue
+		}
+		match := migrationFile.FindStringSubmatch(e.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration %q: name must be like 0001_name.sql", e.Name())
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %q: %w", e.Name(), err)
+		}
+		b, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, fmt.Errorf("migration %q: %w", e.Name(), err)
+		}
+		sum := sha256.Sum256(b)
+		if err := m.add(Migration{
+			Version:  version,
+			Name:     match[2],
+			Checksum: hex.EncodeToString(sum[:]),
+			SQL:      string(b),
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// AddFunc adds a migration running fn, for changes that need Go code such as backfills. It is ordered by
+// version with the .sql files, and must not have the same version as any of them.
+func (m *Migrator) AddFunc(version int64, name string, fn func(ctx context.Context, tx *Tx) error) error {
+	return m.add(Migration{Version: version, Name: name, Func: fn})
+}
+
+// Migrations returns the migrations from the source, ordered by version.
+func (m *Migrator) Migrations() []Migration {
+	return slices.Clone(m.migrations)
+}
+
+// Up applies all pending migrations. See [Migrator.UpTo].
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.UpTo(ctx, math.MaxInt64)
+}
+
+// UpTo applies pending migrations up to and including version, in order, each in its own transaction.
+// Pending migrations older than the latest applied one are also applied. Nothing is applied if any applied
+// migration has changed, returning an error wrapping [ErrMigrationChanged].
+//
+// The database is locked while migrating, so if several instances of an application start at once,
+// one applies the migrations while the others wait.
+func (m *Migrator) UpTo(ctx context.Context, version int64) (err error) {
+	if err := m.createTable(ctx); err != nil {
+		return err
+	}
+	owner := rand.Text()
+	unlock, err := m.lock(ctx, owner)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if uerr := unlock(context.WithoutCancel(ctx)); uerr != nil {
+			err = errors.Join(err, uerr)
+		}
+	}()
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	var changed []error
+	for _, s := range statuses {
+		if s.Changed {
+			changed = append(changed, fmt.Errorf("migration %d %q: %w", s.Version, s.Name, ErrMigrationChanged))
+		}
+	}
+	if err := errors.Join(changed...); err != nil {
+		return err
+	}
+
+	for _, mig := range m.migrations {
+		if mig.Version > version {
+			break
+		}
+		i := slices.IndexFunc(statuses, func(s MigrationStatus) bool { return s.Version == mig.Version })
+		if i >= 0 && statuses[i].Applied {
+			continue
+		}
+		if err := m.apply(ctx, mig, owner); err != nil {
+			return fmt.Errorf("migration %d %q: %w", mig.Version, mig.Name, err)
+		}
+	}
+	return nil
+}
+
+// Status returns the status of each migration in the source or the migrations table, ordered by version.
+// It only reads, so if the migrations table doesn't exist yet, no migrations are applied.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	exists, err := m.dialect.tableExists(ctx, m.db, m.table)
+	if err != nil {
+		return nil, fmt.Errorf("find migrations table: %w", err)
+	}
+
+	var rows []appliedMigration
+	if exists {
+		query := fmt.Sprintf("SELECT version, name, checksum, applied_at FROM %s ORDER BY version", m.table)
+		if err := QueryRows(ctx, m.db, Append(&rows), query); err != nil {
+			return nil, fmt.Errorf("read applied migrations: %w", err)
+		}
+	}
+
+	var statuses []MigrationStatus
+	for _, mig := range m.migrations {
+		statuses = append(statuses, MigrationStatus{Version: mig.Version, Name: mig.Name})
+	}
+	for _, a := range rows {
+		i := slices.IndexFunc(statuses, func(s MigrationStatus) bool { return s.Version == a.version })
+		if i < 0 {
+			statuses = append(statuses, MigrationStatus{Version: a.version, Name: a.name, Applied: true, AppliedAt: a.appliedAt, Missing: true})
+			continue
+		}
+		statuses[i].Applied = true
+		statuses[i].AppliedAt = a.appliedAt
+		statuses[i].Changed = a.checksum != m.migrations[i].Checksum
+	}
+	slices.SortFunc(statuses, func(a, b MigrationStatus) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	return statuses, nil
+}
+
+func (m *Migrator) createTable(ctx context.Context) error {
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		version BIGINT PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`, m.table)
+	if err := Exec(ctx, m.db, query); err != nil {
+		return fmt.Errorf("create migrations table: %w", err)
+	}
+	query = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s_lock (id INTEGER PRIMARY KEY, owner VARCHAR(64) NOT NULL, expires BIGINT NOT NULL)", m.table)
+	if err := Exec(ctx, m.db, query); err != nil {
+		return fmt.Errorf("create migrations lock table: %w", err)
+	}
+	return nil
+}
+
+// lock takes the lock by inserting its only row, waiting while another instance holds it. The lock is extended
+// in the background until it's released with the returned func, which only deletes the row if owner still owns it.
+func (m *Migrator) lock(ctx context.Context, owner string) (unlock func(context.Context) error, err error) {
+	for {
+		now := time.Now()
+		query := m.dialect.bind(fmt.Sprintf("DELETE FROM %s_lock WHERE expires < ?", m.table))
+		if _, err := m.db.ExecContext(ctx, query, now.Unix()); err != nil && !IsBusy(err) {
+			return nil, fmt.Errorf("clear stale migrations lock: %w", err)
+		}
+
+		query = m.dialect.bind(fmt.Sprintf("INSERT INTO %s_lock (id, owner, expires) VALUES (1, ?, ?)", m.table))
+		_, err := m.db.ExecContext(ctx, query, owner, now.Add(m.lockTimeout).Unix())
+		if err == nil {
+			break
+		}
+		if !IsUniqueViolation(err) && !IsBusy(err) {
+			return nil, fmt.Errorf("lock migrations: %w", err)
+		}
+
+		t := time.NewTimer(lockPoll)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return nil, fmt.Errorf("lock migrations: %w", ctx.Err())
+		}
+	}
+
+	extendCtx, stop := context.WithCancel(context.WithoutCancel(ctx))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.extendLock(extendCtx, owner)
+	}()
+
+	return func(ctx context.Context) error {
+		stop()
+		<-done
+		query := m.dialect.bind(fmt.Sprintf("DELETE FROM %s_lock WHERE id = 1 AND owner = ?", m.table))
+		if _, err := m.db.ExecContext(ctx, query, owner); err != nil {
+			return fmt.Errorf("unlock migrations: %w", err)
+		}
+		return nil
+	}, nil
+}
+
+// extendLock moves the expiry of the lock owned by owner forward every third of the lock timeout, until ctx is done.
+func (m *Migrator) extendLock(ctx context.Context, owner string) {
+	t := time.NewTicker(m.lockTimeout / 3)
+	defer t.Stop()
+
+	query := m.dialect.bind(fmt.Sprintf("UPDATE %s_lock SET expires = ? WHERE id = 1 AND owner = ?", m.table))
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+		// errors such as busy while a migration is writing are left to the next tick
+		_, _ = m.db.ExecContext(ctx, query, time.Now().Add(m.lockTimeout).Unix(), owner)
+	}
+}
+
+// extendLockTx moves the expiry of the lock owned by owner forward in tx, returning [ErrMigrationLockLost] if
+// owner no longer holds it.
+func (m *Migrator) extendLockTx(ctx context.Context, tx *Tx, owner string) error {
+	query := m.dialect.bind(fmt.Sprintf("UPDATE %s_lock SET expires = ? WHERE id = 1 AND owner = ?", m.table))
+	res, err := tx.ExecContext(ctx, query, time.Now().Add(m.lockTimeout).Unix(), owner)
+	if err != nil {
+		return fmt.Errorf("extend migrations lock: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+
+	// MySQL doesn't count rows updated to the values they already had, such as when extended within the same second
+	query = m.dialect.bind(fmt.Sprintf("SELECT count(*) FROM %s_lock WHERE id = 1 AND owner = ?", m.table))
+	var n int
+	if err := tx.tx.QueryRowContext(ctx, query, owner).Scan(&n); err != nil {
+		return fmt.Errorf("check migrations lock: %w", err)
+	}
+	if n == 0 {
+		return ErrMigrationLockLost
+	}
+	return nil
+}
+
+// add inserts mig in version order.
+func (m *Migrator) add(mig Migration) error {
+	i, found := slices.BinarySearchFunc(m.migrations, mig.Version, func(e Migration, v int64) int {
+		return cmp.Compare(e.Version, v)
+	})
+	if found {
+		return fmt.Errorf("migrations %q and %q have the same version %d", m.migrations[i].Name, mig.Name, mig.Version)
+	}
+	m.migrations = slices.Insert(m.migrations, i, mig)
+	return nil
+}
+
+func (m *Migrator) apply(ctx context.Context, mig Migration, owner string) error {
+	return InTx(ctx, m.db, nil, func(ctx context.Context, tx *Tx) error {
+		// extending the lock in the transaction works even if other connections can't write while it's open, as with
+		// SQLite, and checks it wasn't taken over before the migration runs
+		if err := m.extendLockTx(ctx, tx, owner); err != nil {
+			return err
+		}
+		if mig.Func != nil {
+			if err := mig.Func(ctx, tx); err != nil {
+				return err
+			}
+		} else if _, err := tx.ExecContext(ctx, mig.SQL); err != nil {
+			// not [Exec], since migrations may contain ? in literals or comments
+			return err
+		}
+		query := m.dialect.bind(fmt.Sprintf("INSERT INTO %s (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)", m.table))
+		_, err := tx.ExecContext(ctx, query, mig.Version, mig.Name, mig.Checksum, time.Now().UTC())
+		return err
+	})
+}
+
+type appliedMigration struct {
+	version   int64
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+func (a *appliedMigration) ScanFrom(columns []string, rows *sql.Rows, buf []any) error {
+	return rows.Scan(&a.version, &a.name, &a.checksum, &a.appliedAt)
+}
@@ -0,0 +1,412 @@
+package sqlb_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"go.senan.xyz/sqlb"
+)
+
+func ExampleMigrator() {
+	ctx := context.Background()
+	db := newDB(ctx)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// typically an embed.FS, narrowed with fs.Sub
+	fsys := fstest.MapFS{
+		"0001_create_users.sql": {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL);")},
+		"0002_add_email.sql":    {Data: []byte("ALTER TABLE users ADD COLUMN email TEXT;")},
+	}
+
+	m, _ := sqlb.NewMigrator(db, fsys, nil)
+	if err := m.Up(ctx); err != nil {
+		fmt.Println(err)
+	}
+
+	statuses, _ := m.Status(ctx)
+	for _, s := range statuses {
+		fmt.Println(s.Version, s.Name, s.Applied)
+	}
+	// Output:
+	// 1 create_users true
+	// 2 add_email true
+}
+
+func TestMigrator(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	fsys := fstest.MapFS{
+		"0001_a.sql":  {Data: []byte("CREATE TABLE a (id INTEGER); -- what? no args")},
+		"0002_b.sql":  {Data: []byte("CREATE TABLE b (id INTEGER);")},
+		"10_c.sql":    {Data: []byte("CREATE TABLE c (id INTEGER); INSERT INTO c VALUES ('?');")},
+		"README.md":   {Data: []byte("not a migration")},
+		"sub/9_x.sql": {Data: []byte("not a migration either")},
+	}
+	m, err := sqlb.NewMigrator(db, fsys, &sqlb.MigratorOptions{Table: "schema_versions"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := func() string {
+		t.Helper()
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var s []string
+		for _, st := range statuses {
+			s = append(s, fmt.Sprintf("%d:%t:%t:%t", st.Version, st.Applied, st.Changed, st.Missing))
+		}
+		return fmt.Sprint(s)
+	}
+
+	// status only reads, so doesn't create the migrations table
+	if got := status(); got != "[1:false:false:false 2:false:false:false 10:false:false:false]" {
+		t.Errorf("before up: %s", got)
+	}
+	var tables int
+	if err := sqlb.QueryRow(ctx, db, sqlb.Scan(&tables), "SELECT count(*) FROM sqlite_master WHERE name LIKE 'schema_versions%'"); err != nil {
+		t.Fatal(err)
+	}
+	if tables != 0 {
+		t.Errorf("got %d migrations tables after status, want 0", tables)
+	}
+
+	if err := m.UpTo(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if got := status(); got != "[1:true:false:false 2:true:false:false 10:false:false:false]" {
+		t.Errorf("after up to 2: %s", got)
+	}
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := status(); got != "[1:true:false:false 2:true:false:false 10:true:false:false]" {
+		t.Errorf("after up: %s", got)
+	}
+
+	// changed and removed migrations
+	fsys["0002_b.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE b (id INTEGER, changed INTEGER);")}
+	delete(fsys, "0001_a.sql")
+	fsys["0011_d.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE d (id INTEGER);")}
+	m, err = sqlb.NewMigrator(db, fsys, &sqlb.MigratorOptions{Table: "schema_versions"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := status(); got != "[1:true:false:true 2:true:true:false 10:true:false:false 11:false:false:false]" {
+		t.Errorf("after change: %s", got)
+	}
+	if err := m.Up(ctx); !errors.Is(err, sqlb.ErrMigrationChanged) {
+		t.Errorf("got %v, want %v", err, sqlb.ErrMigrationChanged)
+	}
+	var n int
+	if err := sqlb.QueryRow(ctx, db, sqlb.Scan(&n), "SELECT count(*) FROM sqlite_master WHERE name = 'd'"); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Error("expected no migrations applied after change")
+	}
+}
+
+func TestMigratorFailure(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	fsys := fstest.MapFS{
+		"1_ok.sql":  {Data: []byte("CREATE TABLE ok (id INTEGER);")},
+		"2_bad.sql": {Data: []byte("CREATE TABLE bad (id INTEGER); INSERT INTO nope VALUES (1);")},
+		"3_ok.sql":  {Data: []byte("CREATE TABLE ok2 (id INTEGER);")},
+	}
+	m, err := sqlb.NewMigrator(db, fsys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(ctx); err == nil {
+		t.Fatal("expected error from bad migration")
+	}
+
+	var tables []string
+	if err := sqlb.QueryRows(ctx, db, sqlb.AppendValue(&tables), "SELECT name FROM sqlite_master WHERE name IN ('ok', 'bad', 'ok2') ORDER BY name"); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(tables) != "[ok]" {
+		t.Errorf("got tables %v, want [ok]", tables)
+	}
+}
+
+func TestNewMigratorErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, fsys := range []fstest.MapFS{
+		{"create_users.sql": {}},
+		{"1_a.sql": {}, "01_b.sql": {}},
+	} {
+		if _, err := sqlb.NewMigrator(nil, fsys, nil); err == nil {
+			t.Errorf("%v: expected error", fsys)
+		}
+	}
+}
+
+func TestMigratorFunc(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	fsys := fstest.MapFS{
+		"1_create.sql": {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, upper TEXT);")},
+		"3_drop.sql":   {Data: []byte("ALTER TABLE users DROP COLUMN name;")},
+	}
+	m, err := sqlb.NewMigrator(db, fsys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddFunc(2, "backfill_upper", func(ctx context.Context, tx *sqlb.Tx) error {
+		if err := sqlb.Exec(ctx, tx, "INSERT INTO users (name) VALUES (?), (?)", "a", "b"); err != nil {
+			return err
+		}
+		return sqlb.Exec(ctx, tx, "UPDATE users SET upper = upper(name)")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddFunc(3, "dup", nil); err == nil {
+		t.Error("expected error for duplicate version")
+	}
+
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, mig := range m.Migrations() {
+		names = append(names, mig.Name)
+	}
+	if fmt.Sprint(names) != "[create backfill_upper drop]" {
+		t.Errorf("got migrations %v", names)
+	}
+	var upper []string
+	if err := sqlb.QueryRows(ctx, db, sqlb.AppendValue(&upper), "SELECT upper FROM users ORDER BY id"); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(upper) != "[A B]" {
+		t.Errorf("got %v, want [A B]", upper)
+	}
+}
+
+func TestMigratorLock(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	path := "file:" + filepath.Join(t.TempDir(), "db.sqlite")
+
+	fsys := fstest.MapFS{
+		"1_create.sql": {Data: []byte("CREATE TABLE counts (n INTEGER);")},
+	}
+
+	var runs atomic.Int32
+	var wg sync.WaitGroup
+	for range 4 {
+		// separate handles, like separate instances of an application
+		db, err := sqlb.OpenSQLite("sqlite3", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		m, err := sqlb.NewMigrator(db, fsys, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.AddFunc(2, "slow", func(ctx context.Context, tx *sqlb.Tx) error {
+			runs.Add(1)
+			time.Sleep(50 * time.Millisecond)
+			return sqlb.Exec(ctx, tx, "INSERT INTO counts (n) VALUES (1)")
+		}); err != nil {
+			t.Fatal(err)
+		}
+		wg.Go(func() {
+			if err := m.Up(ctx); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	wg.Wait()
+
+	if n := runs.Load(); n != 1 {
+		t.Errorf("got %d runs of migration, want 1", n)
+	}
+}
+
+func TestMigratorLockStale(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	fsys := fstest.MapFS{"1_create.sql": {Data: []byte("CREATE TABLE a (id INTEGER);")}}
+	m, err := sqlb.NewMigrator(db, fsys, &sqlb.MigratorOptions{LockTimeout: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// creates the tables without applying anything
+	if err := m.UpTo(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// held by another instance
+	if err := sqlb.Exec(ctx, db, "INSERT INTO sqlb_migrations_lock (id, owner, expires) VALUES (1, 'other', ?)", time.Now().Add(time.Hour).Unix()); err != nil {
+		t.Fatal(err)
+	}
+	cctx, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+	defer cancel()
+	if err := m.Up(cctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// held by an instance that stopped
+	if err := sqlb.Exec(ctx, db, "UPDATE sqlb_migrations_lock SET expires = ?", time.Now().Add(-time.Second).Unix()); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := sqlb.QueryRow(ctx, db, sqlb.Scan(&n), "SELECT count(*) FROM sqlb_migrations_lock"); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("got %d locks after up, want 0", n)
+	}
+}
+
+func TestMigratorLockExtend(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	path := "file:" + filepath.Join(t.TempDir(), "db.sqlite")
+
+	open := func() *sqlb.Migrator {
+		t.Helper()
+		// a pool rather than OpenSQLite's single writer, so the lock can be extended while a migration holds a connection
+		db, err := sql.Open("sqlite3", path+"?_pragma=busy_timeout(1000)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = db.Close() })
+		m, err := sqlb.NewMigrator(db, fstest.MapFS{}, &sqlb.MigratorOptions{LockTimeout: time.Second})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	// a migration running past the lock timeout keeps the lock
+	slow, other := open(), open()
+	started := make(chan struct{})
+	if err := slow.AddFunc(1, "slow", func(ctx context.Context, tx *sqlb.Tx) error {
+		close(started)
+		time.Sleep(3 * time.Second)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- slow.Up(ctx) }()
+	<-started
+
+	cctx, cancel := context.WithTimeout(ctx, 2500*time.Millisecond)
+	defer cancel()
+	if err := other.UpTo(cctx, 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	// the lock is free once released
+	if err := other.UpTo(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigratorUnlockOwner(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	m, err := sqlb.NewMigrator(db, fstest.MapFS{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the lock is taken over as stale by another instance while migrating
+	if err := m.AddFunc(1, "takeover", func(ctx context.Context, tx *sqlb.Tx) error {
+		return sqlb.Exec(ctx, tx, "UPDATE sqlb_migrations_lock SET owner = 'other'")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var owners []string
+	if err := sqlb.QueryRows(ctx, db, sqlb.AppendValue(&owners), "SELECT owner FROM sqlb_migrations_lock"); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(owners) != "[other]" {
+		t.Errorf("got owners %q, want the other instance's lock kept", owners)
+	}
+}
+
+func TestMigratorLockLost(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	m, err := sqlb.NewMigrator(db, fstest.MapFS{
+		"0002_second.sql": {Data: []byte("CREATE TABLE second (id INTEGER)")},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the lock is taken over as stale by another instance between migrations
+	if err := m.AddFunc(1, "takeover", func(ctx context.Context, tx *sqlb.Tx) error {
+		return sqlb.Exec(ctx, tx, "UPDATE sqlb_migrations_lock SET owner = 'other'")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(ctx); !errors.Is(err, sqlb.ErrMigrationLockLost) {
+		t.Fatalf("got %v, want %v", err, sqlb.ErrMigrationLockLost)
+	}
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 || !statuses[0].Applied || statuses[1].Applied {
+		t.Errorf("expected only the first migration applied, got %+v", statuses)
+	}
+}
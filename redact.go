@@ -0,0 +1,100 @@
+package sqlb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+)
+
+const redacted = "<redacted>"
+
+// Sensitive is a wrapper for values that should not appear in logs. The database sees the wrapped value,
+// but it is formatted as <redacted> by the fmt, log/slog, and encoding/json packages.
+type Sensitive[T any] struct {
+	Data T
+}
+
+var _ sql.Scanner = &Sensitive[struct{}]{}
+var _ driver.Valuer = Sensitive[struct{}]{}
+var _ fmt.Formatter = Sensitive[struct{}]{}
+var _ slog.LogValuer = Sensitive[struct{}]{}
+var _ json.Marshaler = Sensitive[struct{}]{}
+
+func NewSensitive[T any](t T) Sensitive[T] {
+	return Sensitive[T]{Data: t}
+}
+
+func (s *Sensitive[T]) Scan(value any) error {
+	var n sql.Null[T]
+	if err := n.Scan(value); err != nil {
+		return err
+	}
+	s.Data = n.V
+	return nil
+}
+
+func (s Sensitive[T]) Value() (driver.Value, error) {
+	// handles Valuers, including nil pointers to them, like an unwrapped argument
+	return driver.DefaultParameterConverter.ConvertValue(s.Data)
+}
+
+func (s Sensitive[T]) Format(f fmt.State, verb rune) {
+	_, _ = io.WriteString(f, redacted)
+}
+
+func (s Sensitive[T]) LogValue() slog.Value {
+	return slog.StringValue(redacted)
+}
+
+func (s Sensitive[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(redacted)
+}
+
+type sensitive interface {
+	sensitive()
+}
+
+func (s Sensitive[T]) sensitive() {}
+
+type redactedColumnsContextKey struct{}
+
+// WithRedactedColumns returns a context that will redact arguments for the named columns in log events,
+// for arguments from the [sql.NamedArg] values of [InsertSQL] and [UpdateSQL].
+func WithRedactedColumns(ctx context.Context, columns ...string) context.Context {
+	columns = append(slices.Clone(redactedColumns(ctx)), columns...)
+	return context.WithValue(ctx, redactedColumnsContextKey{}, columns)
+}
+
+func redactedColumns(ctx context.Context) []string {
+	c, _ := ctx.Value(redactedColumnsContextKey{}).([]string)
+	return c
+}
+
+// redactArgs wraps args for redacted columns in [Sensitive], copying args if any are redacted.
+func redactArgs(ctx context.Context, args []any, q Query) []any {
+	columns := redactedColumns(ctx)
+	if len(columns) == 0 || q.query == nil {
+		return args
+	}
+
+	_, _, names := q.sql(true)
+	var out []any
+	for i, name := range names {
+		if name == "" || i >= len(args) || !slices.Contains(columns, name) {
+			continue
+		}
+		if out == nil {
+			out = slices.Clone(args)
+		}
+		out[i] = Sensitive[any]{Data: args[i]}
+	}
+	if out == nil {
+		return args
+	}
+	return out
+}
@@ -0,0 +1,138 @@
+package sqlb_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"go.senan.xyz/sqlb"
+)
+
+func ExampleSensitive() {
+	ctx := context.Background()
+	db := newDB(ctx)
+	defer db.Close()
+
+	ctx = sqlb.WithLogEventFunc(ctx, func(ctx context.Context, ev sqlb.LogEvent) {
+		fmt.Println(ev.Query, ev.Args)
+	})
+
+	_ = sqlb.Exec(ctx, db, "INSERT INTO tasks (name, age) VALUES (?, ?)", sqlb.NewSensitive("alice"), 30)
+
+	var name string
+	_ = sqlb.QueryRow(ctx, db, sqlb.Scan(&name), "SELECT name FROM tasks WHERE age = ?", 30)
+	fmt.Println(name)
+	// Output:
+	// INSERT INTO tasks (name, age) VALUES (?, ?) [<redacted> 30]
+	// SELECT name FROM tasks WHERE age = ? [30]
+	// alice
+}
+
+func ExampleWithRedactedColumns() {
+	ctx := context.Background()
+	db := newDB(ctx)
+	defer db.Close()
+
+	ctx = sqlb.WithLogEventFunc(ctx, func(ctx context.Context, ev sqlb.LogEvent) {
+		fmt.Println(ev.Query, ev.Args)
+	})
+	ctx = sqlb.WithRedactedColumns(ctx, "name")
+
+	task := Task{Name: "alice", Age: 30}
+	_ = sqlb.QueryRow(ctx, db, &task, "INSERT INTO tasks ? RETURNING *", sqlb.InsertSQL(task))
+
+	task.Name = "bob"
+	_ = sqlb.Exec(ctx, db, "UPDATE tasks SET ? WHERE id = ?", sqlb.UpdateSQL(task), task.ID)
+	// Output:
+	// INSERT INTO tasks (name, age) VALUES (?, ?) RETURNING * [<redacted> 30]
+	// UPDATE tasks SET name=? , age=? WHERE id = ? [<redacted> 30 1]
+}
+
+func TestSensitiveFormat(t *testing.T) {
+	t.Parallel()
+
+	s := sqlb.NewSensitive("hunter2")
+	for _, format := range []string{"%v", "%+v", "%#v", "%s", "%q"} {
+		if got := fmt.Sprintf(format, s); got != "<redacted>" {
+			t.Errorf("%s: got %q", format, got)
+		}
+	}
+
+	var buf bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buf, nil)).Info("query", "args", []any{s})
+	if strings.Contains(buf.String(), "hunter2") {
+		t.Errorf("slog output contains sensitive value: %s", buf.String())
+	}
+}
+
+func TestSensitiveScanValue(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+
+	when := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	details := sqlb.NewSensitive(sqlb.NewJSON(map[string]string{"token": "abc"}))
+	if err := sqlb.Exec(ctx, db, "INSERT INTO books (details) VALUES (?)", details); err != nil {
+		t.Fatal(err)
+	}
+
+	var got sqlb.Sensitive[sqlb.JSON[map[string]string]]
+	if err := sqlb.QueryRow(ctx, db, sqlb.Scan(&got), "SELECT details FROM books"); err != nil {
+		t.Fatal(err)
+	}
+	if got.Data.Data["token"] != "abc" {
+		t.Errorf("unexpected scanned value: %v", got.Data.Data)
+	}
+
+	var gotTime sqlb.Sensitive[time.Time]
+	var gotAge sqlb.Sensitive[int]
+	if err := sqlb.QueryRow(ctx, db, sqlb.Scan(&gotTime, &gotAge), "SELECT ?, ?", sqlb.NewSensitive(when), sqlb.NewSensitive(42)); err != nil {
+		t.Fatal(err)
+	}
+	if !gotTime.Data.Equal(when) || gotAge.Data != 42 {
+		t.Errorf("unexpected scanned values: %v %v", gotTime.Data, gotAge.Data)
+	}
+
+	// a nil pointer to a Valuer is NULL, as it is unwrapped
+	var gotNull sql.NullString
+	if err := sqlb.QueryRow(ctx, db, sqlb.Scan(&gotNull), "SELECT ?", sqlb.NewSensitive((*sql.NullString)(nil))); err != nil {
+		t.Fatal(err)
+	}
+	if gotNull.Valid {
+		t.Errorf("got %q, want NULL", gotNull.String)
+	}
+}
+
+func TestRedactedColumnsNested(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+
+	var args []any
+	ctx = sqlb.WithLogEventFunc(ctx, func(ctx context.Context, ev sqlb.LogEvent) {
+		args = ev.Args
+	})
+	ctx = sqlb.WithRedactedColumns(ctx, "age")
+
+	var q sqlb.Query
+	q.Append("INSERT INTO tasks ?", sqlb.InsertSQL(Task{Name: "a", Age: 1}, Task{Name: "b", Age: 2}))
+	q.Append("RETURNING ?", sqlb.NewQuery("id + ?", 0))
+
+	var ids []int
+	if err := sqlb.QueryRows(ctx, db, sqlb.AppendValue(&ids), "?", q); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(args); got != "[a <redacted> b <redacted> 0]" {
+		t.Errorf("unexpected args %s", got)
+	}
+	if len(ids) != 2 {
+		t.Errorf("got %d ids, want 2", len(ids))
+	}
+}
@@ -0,0 +1,70 @@
+package sqlb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+var errNoDB = errors.New("no database handle in context")
+
+type dbContextKey struct{}
+
+// WithDB returns a context carrying db as the handle for [DBFromContext] and [Resolver].
+func WithDB(ctx context.Context, db DB) context.Context {
+	return context.WithValue(ctx, dbContextKey{}, db)
+}
+
+// DBFromContext returns the handle to use for ctx. That is the handle from [WithDB] if there is one, otherwise fallback,
+// replaced by the innermost transaction from [BeginTx] or [InTx] begun on it, so code can't accidentally use another
+// connection while a transaction is open. A transaction replaces handles wrapping the one it was begun on too: a
+// [*StmtCache] uses its cached statements through [StmtCache.Tx], a [*MiddlewareDB] runs its middleware on the
+// transaction, and a [*Router] uses it for every operation. If there is no handle at all, the innermost transaction
+// is used.
+func DBFromContext(ctx context.Context, fallback DB) DB {
+	db := fallback
+	if d, ok := ctx.Value(dbContextKey{}).(DB); ok {
+		db = d
+	}
+	t, ok := ctx.Value(txContextKey{}).(*Tx)
+	if !ok {
+		return db
+	}
+	if db == nil {
+		return t
+	}
+	for ; t != nil; t = t.root.outer {
+		if t.begunOn(db) {
+			return t.bind(db)
+		}
+	}
+	return db
+}
+
+// Resolver is a handle that runs each operation on the handle from [DBFromContext], so layers can
+// be given one handle and pick up the current transaction from context.
+type Resolver struct {
+	fallback DB
+}
+
+// NewResolver returns a [Resolver] using fallback when the context has no handle. fallback may be nil
+// if every context is expected to have one, in which case operations without one return an error.
+func NewResolver(fallback DB) *Resolver {
+	return &Resolver{fallback: fallback}
+}
+
+func (r *Resolver) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	db := DBFromContext(ctx, r.fallback)
+	if db == nil {
+		return nil, errNoDB
+	}
+	return db.QueryContext(ctx, query, args...)
+}
+
+func (r *Resolver) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	db := DBFromContext(ctx, r.fallback)
+	if db == nil {
+		return nil, errNoDB
+	}
+	return db.ExecContext(ctx, query, args...)
+}
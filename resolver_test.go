@@ -0,0 +1,263 @@
+package sqlb_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.senan.xyz/sqlb"
+)
+
+func ExampleResolver() {
+	ctx := context.Background()
+	db := newDB(ctx)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// repositories are given one handle, and use the transaction from context if there is one
+	rdb := sqlb.NewResolver(db)
+	createTask := func(ctx context.Context, name string) error {
+		return sqlb.Exec(ctx, rdb, "INSERT INTO tasks (name) VALUES (?)", name)
+	}
+	countTasks := func(ctx context.Context) (n int) {
+		_ = sqlb.QueryRow(ctx, rdb, sqlb.Scan(&n), "SELECT count(*) FROM tasks")
+		return n
+	}
+
+	txCtx, tx, _ := sqlb.BeginTx(ctx, db, nil)
+	_ = createTask(txCtx, "a")
+	fmt.Println(countTasks(txCtx))
+	_ = tx.Rollback()
+
+	fmt.Println(countTasks(ctx))
+	// Output:
+	// 1
+	// 0
+}
+
+func TestDBFromContext(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	other := newDB(ctx)
+	defer other.Close()
+
+	if got := sqlb.DBFromContext(ctx, db); got != db {
+		t.Errorf("got %T, want fallback", got)
+	}
+	withCtx := sqlb.WithDB(ctx, other)
+	if got := sqlb.DBFromContext(withCtx, db); got != other {
+		t.Errorf("got %T, want handle from context", got)
+	}
+
+	txCtx, tx, err := sqlb.BeginTx(withCtx, other, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if got := sqlb.DBFromContext(txCtx, db); got != tx {
+		t.Errorf("got %T, want transaction", got)
+	}
+	spCtx, sp, err := sqlb.BeginTx(txCtx, other, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sqlb.DBFromContext(spCtx, db); got != sp {
+		t.Errorf("got %T, want savepoint", got)
+	}
+
+	// a transaction only replaces the handle it was begun on
+	if got := sqlb.DBFromContext(sqlb.WithDB(spCtx, db), nil); got != db {
+		t.Errorf("got %T, want handle from context", got)
+	}
+	if got := sqlb.DBFromContext(ctx, nil); got != nil {
+		t.Errorf("got %T, want nil", got)
+	}
+
+	if _, err := sqlb.NewResolver(nil).ExecContext(ctx, "SELECT 1"); err == nil {
+		t.Error("expected error without handle")
+	}
+}
+
+func TestDBFromContextInTx(t *testing.T) {
+	t.Parallel()
+	// the SQLite writer pool has one connection, so resolving to the pool inside the transaction would deadlock
+	db := newSQLiteDB(t)
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	rdb := sqlb.NewResolver(db)
+	err := sqlb.InTx(ctx, db, nil, func(ctx context.Context, tx *sqlb.Tx) error {
+		if got := sqlb.DBFromContext(ctx, db); got != tx {
+			return fmt.Errorf("got %T, want transaction", got)
+		}
+		if err := sqlb.Exec(ctx, rdb, "INSERT INTO tasks (name) VALUES (?)", "a"); err != nil {
+			return err
+		}
+		return sqlb.InTx(ctx, db, nil, func(ctx context.Context, sp *sqlb.Tx) error {
+			if got := sqlb.DBFromContext(ctx, db); got != sp {
+				return fmt.Errorf("got %T, want savepoint", got)
+			}
+			return sqlb.Exec(ctx, rdb, "INSERT INTO tasks (name) VALUES (?)", "b")
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	if err := sqlb.QueryRow(ctx, db, sqlb.Scan(&n), "SELECT count(*) FROM tasks"); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("got %d tasks, want 2", n)
+	}
+}
+
+func TestDBFromContextStmtCache(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	var prepareCalls int
+	pdb := &prepareWrap{db, func(ctx context.Context, query string) {
+		prepareCalls++
+	}}
+	cache := sqlb.NewStmtCache(pdb)
+	defer cache.Close()
+
+	rdb := sqlb.NewResolver(cache)
+	if err := sqlb.Exec(ctx, rdb, "INSERT INTO tasks (name) VALUES (?)", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	var hits int
+	txCtx := sqlb.WithLogEventFunc(ctx, func(ctx context.Context, ev sqlb.LogEvent) {
+		if ev.Hit {
+			hits++
+		}
+	})
+	txCtx, tx, err := sqlb.BeginTx(txCtx, pdb, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	if _, ok := sqlb.DBFromContext(txCtx, cache).(*sqlb.TxStmtCache); !ok {
+		t.Errorf("expected transaction view of cache")
+	}
+	spCtx, _, err := sqlb.BeginTx(txCtx, pdb, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ctx := range []context.Context{txCtx, spCtx, txCtx} {
+		if err := sqlb.Exec(ctx, rdb, "INSERT INTO tasks (name) VALUES (?)", "b"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if prepareCalls != 1 || hits != 3 {
+		t.Errorf("got %d prepareCalls and %d hits, want 1 and 3", prepareCalls, hits)
+	}
+}
+
+func TestDBFromContextOtherDB(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	other := newDB(ctx)
+	defer other.Close()
+	if err := sqlb.Exec(ctx, other, "CREATE TABLE notes (body TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+
+	rother := sqlb.NewResolver(other)
+	err := sqlb.InTx(ctx, db, nil, func(ctx context.Context, tx *sqlb.Tx) error {
+		if got := sqlb.DBFromContext(ctx, other); got != other {
+			return fmt.Errorf("got %T, want other handle", got)
+		}
+		if err := sqlb.Exec(ctx, rother, "INSERT INTO notes (body) VALUES (?)", "a"); err != nil {
+			return err
+		}
+		// a transaction begun on the other handle is used for it, and the first one still for db
+		return sqlb.InTx(ctx, other, nil, func(ctx context.Context, otx *sqlb.Tx) error {
+			if got := sqlb.DBFromContext(ctx, other); got != otx {
+				return fmt.Errorf("got %T, want other transaction", got)
+			}
+			if got := sqlb.DBFromContext(ctx, db); got != tx {
+				return fmt.Errorf("got %T, want transaction", got)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	if err := sqlb.QueryRow(ctx, other, sqlb.Scan(&n), "SELECT count(*) FROM notes"); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("got %d notes, want 1", n)
+	}
+}
+
+func TestDBFromContextMiddleware(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	var ops int
+	wdb := sqlb.Wrap(db, func(ctx context.Context, op *sqlb.Op, next sqlb.Next) error {
+		ops++
+		return next(ctx, op)
+	})
+
+	rdb := sqlb.NewResolver(nil)
+	err := sqlb.InTx(sqlb.WithDB(ctx, wdb), db, nil, func(ctx context.Context, tx *sqlb.Tx) error {
+		if _, ok := sqlb.DBFromContext(ctx, nil).(*sqlb.MiddlewareDB); !ok {
+			return fmt.Errorf("expected middleware over the transaction")
+		}
+		// with one connection, this would deadlock if it didn't run in the transaction
+		if err := sqlb.Exec(ctx, rdb, "INSERT INTO tasks (name) VALUES (?)", "a"); err != nil {
+			return err
+		}
+		var n int
+		if err := sqlb.QueryRow(ctx, rdb, sqlb.Scan(&n), "SELECT count(*) FROM tasks"); err != nil {
+			return err
+		}
+		if n != 1 {
+			return fmt.Errorf("got %d tasks in transaction, want 1", n)
+		}
+		return errors.New("roll back")
+	})
+	if err == nil || err.Error() != "roll back" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ops != 2 {
+		t.Errorf("got %d ops, want 2", ops)
+	}
+
+	var n int
+	if err := sqlb.QueryRow(ctx, db, sqlb.Scan(&n), "SELECT count(*) FROM tasks"); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("got %d tasks after rollback, want 0", n)
+	}
+}
@@ -0,0 +1,232 @@
+package sqlb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Route overrides where a [Router] sends operations, see [WithRoute].
+type Route int
+
+const (
+	RouteAuto    Route = iota // reads to replicas and writes to the primary
+	RoutePrimary              // always to the primary
+	RouteReplica              // always to a replica, if one is healthy
+)
+
+func (r Route) String() string {
+	switch r {
+	case RouteAuto:
+		return "auto"
+	case RoutePrimary:
+		return "primary"
+	case RouteReplica:
+		return "replica"
+	default:
+		return "unknown"
+	}
+}
+
+type routeContextKey struct{}
+
+// WithRoute returns a context that overrides where a [Router] sends operations, for example to read from
+// the primary when replication lag isn't acceptable.
+func WithRoute(ctx context.Context, r Route) context.Context {
+	return context.WithValue(ctx, routeContextKey{}, r)
+}
+
+type stickyContextKey struct{}
+
+// WithStickyPrimary returns a context where, once a [Router] has sent a write to the primary, later reads
+// are also sent to the primary, so they see the write regardless of replication lag.
+func WithStickyPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, stickyContextKey{}, new(atomic.Bool))
+}
+
+// Router is a handle that sends reads to replicas and writes to a primary. Queries starting with SELECT, WITH,
+// VALUES, or SHOW are reads, unless they also write, for example with a data-modifying WITH or SELECT FOR UPDATE.
+// Everything else, including transactions from [Router.BeginTx], goes to the primary.
+//
+// Replicas are used round-robin. A replica failing with a connection error is skipped for a cooldown period, with the
+// operation retried on the next replica, or the primary if none are healthy. See also [Router.CheckReplicas].
+//
+// A Router can be wrapped with [NewStmtCache], which routes each operation like the Router does and caches statements
+// for each database. Transactions use the statements cached for the primary.
+type Router struct {
+	primary  DB
+	replicas []*replica
+	next     atomic.Uint32
+	cooldown time.Duration
+}
+
+type replica struct {
+	db        DB
+	downUntil atomic.Int64 // unix nanoseconds
+}
+
+// RouterOption configures a [Router].
+type RouterOption func(*Router)
+
+// RouterCooldown sets how long a [Router] skips a replica after it fails with a connection error. The default is 5s.
+func RouterCooldown(d time.Duration) RouterOption {
+	return func(r *Router) {
+		r.cooldown = d
+	}
+}
+
+// NewRouter returns a [Router] for primary and replicas, which are typically [*sql.DB] or [*StmtCache].
+// With no replicas, everything goes to the primary.
+func NewRouter(primary DB, replicas []DB, opts ...RouterOption) *Router {
+	r := &Router{
+		primary:  primary,
+		cooldown: 5 * time.Second,
+	}
+	for _, db := range replicas {
+		r.replicas = append(r.replicas, &replica{db: db})
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+func (r *Router) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return route(ctx, r, r.isRead(ctx, query), func(_ int, db DB) (*sql.Rows, error) {
+		return db.QueryContext(ctx, query, args...)
+	})
+}
+
+func (r *Router) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return route(ctx, r, r.isRead(ctx, query), func(_ int, db DB) (sql.Result, error) {
+		return db.ExecContext(ctx, query, args...)
+	})
+}
+
+// PrepareContext prepares query on the primary or a replica. Only the query is used to route it, since the statement
+// may be reused with other contexts. [StmtCache] doesn't use it, and routes each operation instead.
+func (r *Router) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return route(ctx, r, isReadQuery(query), func(_ int, db DB) (*sql.Stmt, error) {
+		pdb, ok := db.(PrepareDB)
+		if !ok {
+			return nil, fmt.Errorf("%T does not support PrepareContext", db)
+		}
+		return pdb.PrepareContext(ctx, query)
+	})
+}
+
+// BeginTx starts a transaction on the primary.
+func (r *Router) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	db, ok := r.primary.(TxDB)
+	if !ok {
+		return nil, fmt.Errorf("%T does not support BeginTx", r.primary)
+	}
+	return db.BeginTx(ctx, opts)
+}
+
+// CheckReplicas pings replicas that support PingContext, such as [*sql.DB], marking them healthy or
+// skipping them for the cooldown period. It returns the errors from replicas that failed.
+// Call it periodically to find replicas which are down before any operations fail on them.
+func (r *Router) CheckReplicas(ctx context.Context) error {
+	var errs []error
+	for i, rep := range r.replicas {
+		p, ok := rep.db.(interface{ PingContext(context.Context) error })
+		if !ok {
+			continue
+		}
+		if err := p.PingContext(ctx); err != nil {
+			rep.markDown(r.cooldown)
+			errs = append(errs, fmt.Errorf("replica %d: %w", i, err))
+			continue
+		}
+		rep.downUntil.Store(0)
+	}
+	return errors.Join(errs...)
+}
+
+// isRead reports whether an operation should go to a replica, and marks ctx as sticky if it's a write.
+func (r *Router) isRead(ctx context.Context, query string) bool {
+	switch route, _ := ctx.Value(routeContextKey{}).(Route); route {
+	case RoutePrimary:
+		return false
+	case RouteReplica:
+		return true
+	}
+
+	sticky, _ := ctx.Value(stickyContextKey{}).(*atomic.Bool)
+	if !isReadQuery(query) {
+		if sticky != nil {
+			sticky.Store(true)
+		}
+		return false
+	}
+	return sticky == nil || !sticky.Load()
+}
+
+// route calls f with a healthy replica for reads, trying the next after connection errors, or otherwise with the primary.
+// f is also given the target, which is 0 for the primary and n+1 for replica n.
+func route[T any](ctx context.Context, r *Router, read bool, f func(target int, db DB) (T, error)) (T, error) {
+	if read && len(r.replicas) > 0 {
+		start := r.next.Add(1)
+		for i := range len(r.replicas) {
+			n := (int(start) + i) % len(r.replicas)
+			rep := r.replicas[n]
+			if rep.isDown() {
+				continue
+			}
+			res, err := f(n+1, rep.db)
+			if err == nil || !isConnError(ctx, err) {
+				return res, err
+			}
+			rep.markDown(r.cooldown)
+		}
+	}
+	return f(0, r.primary)
+}
+
+func (rep *replica) isDown() bool {
+	return time.Now().UnixNano() < rep.downUntil.Load()
+}
+
+func (rep *replica) markDown(d time.Duration) {
+	rep.downUntil.Store(time.Now().Add(d).UnixNano())
+}
+
+// isConnError reports whether err means the database couldn't be reached, rather than the operation failing.
+func isConnError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var ne net.Error
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) || errors.As(err, &ne)
+}
+
+var (
+	readKeywords  = []string{"select", "with", "values", "show"}
+	writeKeywords = []string{"insert", "update", "delete", "merge", "into", "create", "alter", "drop", "truncate", "lock", "share"}
+)
+
+// isReadQuery reports whether query only reads, erring on the side of writes. Using the [Fingerprint]
+// means keywords in comments and string literals are ignored.
+func isReadQuery(query string) bool {
+	words := strings.FieldsFunc(Fingerprint(query), func(r rune) bool {
+		return r >= 0x80 || !isIdent(byte(r))
+	})
+	if len(words) == 0 || !slices.Contains(readKeywords, words[0]) {
+		return false
+	}
+	for _, w := range words[1:] {
+		// e.g. WITH ... INSERT, SELECT ... INTO, or SELECT ... FOR UPDATE
+		if slices.Contains(writeKeywords, w) {
+			return false
+		}
+	}
+	return true
+}
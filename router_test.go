@@ -0,0 +1,226 @@
+package sqlb_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.senan.xyz/sqlb"
+)
+
+func ExampleRouter() {
+	ctx := context.Background()
+	newNamedDB := func(name string) *sql.DB {
+		db := newDB(ctx)
+		db.SetMaxOpenConns(1)
+		_ = sqlb.Exec(ctx, db, "INSERT INTO tasks (name) VALUES (?)", name)
+		return db
+	}
+	primary, replica := newNamedDB("primary"), newNamedDB("replica")
+	defer primary.Close()
+	defer replica.Close()
+
+	router := sqlb.NewRouter(primary, []sqlb.DB{replica})
+
+	var name string
+	_ = sqlb.QueryRow(ctx, router, sqlb.Scan(&name), "SELECT name FROM tasks")
+	fmt.Println(name)
+	_ = sqlb.QueryRow(ctx, router, sqlb.Scan(&name), "UPDATE tasks SET age = 1 RETURNING name")
+	fmt.Println(name)
+
+	_ = sqlb.QueryRow(sqlb.WithRoute(ctx, sqlb.RoutePrimary), router, sqlb.Scan(&name), "SELECT name FROM tasks")
+	fmt.Println(name)
+	// Output:
+	// replica
+	// primary
+	// primary
+}
+
+var errRouted = errors.New("routed")
+
+// routeRecorder returns a handle that records its name for each operation, without running it.
+func routeRecorder(name string, got *[]string, err error) sqlb.DB {
+	return sqlb.Wrap(&sql.DB{}, func(ctx context.Context, op *sqlb.Op, next sqlb.Next) error {
+		*got = append(*got, name)
+		if err != nil {
+			return err
+		}
+		return errRouted
+	})
+}
+
+func TestRouterQueries(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+
+	var got []string
+	router := sqlb.NewRouter(routeRecorder("primary", &got, nil), []sqlb.DB{routeRecorder("replica", &got, nil)})
+
+	cases := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT * FROM users", "replica"},
+		{"  select 'delete' /* insert */ -- update", "replica"},
+		{"WITH x AS (SELECT 1) SELECT * FROM x", "replica"},
+		{"VALUES (1), (2)", "replica"},
+		{"SHOW search_path", "replica"},
+		{"INSERT INTO users (name) VALUES (?) RETURNING id", "primary"},
+		{"UPDATE users SET name = ?", "primary"},
+		{"WITH x AS (DELETE FROM users RETURNING *) SELECT * FROM x", "primary"},
+		{"SELECT * FROM users FOR UPDATE", "primary"},
+		{"SELECT * FROM users FOR SHARE", "primary"},
+		{"SELECT * INTO backup FROM users", "primary"},
+		{"PRAGMA journal_mode", "primary"},
+		{"", "primary"},
+	}
+	for _, c := range cases {
+		got = nil
+		_, _ = router.QueryContext(ctx, c.query)
+		if len(got) != 1 || got[0] != c.want {
+			t.Errorf("%q: got %q, want %s", c.query, got, c.want)
+		}
+	}
+}
+
+func TestRouterContext(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+
+	var got []string
+	router := sqlb.NewRouter(routeRecorder("primary", &got, nil), []sqlb.DB{
+		routeRecorder("replica1", &got, nil),
+		routeRecorder("replica2", &got, nil),
+	})
+
+	const read, write = "SELECT 1", "DELETE FROM users"
+
+	_, _ = router.QueryContext(ctx, read)
+	_, _ = router.QueryContext(ctx, read)
+	_, _ = router.QueryContext(sqlb.WithRoute(ctx, sqlb.RoutePrimary), read)
+	_, _ = router.ExecContext(sqlb.WithRoute(ctx, sqlb.RouteReplica), write)
+
+	sticky := sqlb.WithStickyPrimary(ctx)
+	_, _ = router.QueryContext(sticky, read)
+	_, _ = router.ExecContext(sticky, write)
+	_, _ = router.QueryContext(sticky, read)
+	_, _ = router.QueryContext(sqlb.WithRoute(sticky, sqlb.RouteReplica), read)
+
+	want := []string{
+		"replica2", "replica1", "primary", "replica2",
+		"replica1", "primary", "primary", "replica2",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type pingDB struct {
+	sqlb.DB
+	err error
+}
+
+func (p pingDB) PingContext(context.Context) error {
+	return p.err
+}
+
+func TestRouterHealth(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+
+	var got []string
+	down := fmt.Errorf("dial: %w", driver.ErrBadConn)
+	router := sqlb.NewRouter(routeRecorder("primary", &got, nil), []sqlb.DB{
+		routeRecorder("down", &got, down),
+		routeRecorder("up", &got, nil),
+		pingDB{routeRecorder("unpingable", &got, nil), errors.New("connection refused")},
+	}, sqlb.RouterCooldown(time.Hour))
+
+	// query errors don't mark replicas as down
+	failing := sqlb.NewRouter(routeRecorder("primary", &got, nil), []sqlb.DB{routeRecorder("failing", &got, errors.New("syntax error"))})
+	_, _ = failing.QueryContext(ctx, "SELECT")
+	_, _ = failing.QueryContext(ctx, "SELECT")
+
+	if err := router.CheckReplicas(ctx); err == nil {
+		t.Error("expected error from unpingable replica")
+	}
+	for range 3 {
+		if _, err := router.QueryContext(ctx, "SELECT 1"); !errors.Is(err, errRouted) {
+			t.Errorf("got %v, want %v", err, errRouted)
+		}
+	}
+
+	want := []string{"failing", "failing", "up", "down", "up", "up"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// with no healthy replicas, reads go to the primary
+	got = nil
+	all := sqlb.NewRouter(routeRecorder("primary", &got, nil), []sqlb.DB{routeRecorder("down", &got, down)})
+	_, _ = all.QueryContext(ctx, "SELECT 1")
+	_, _ = all.QueryContext(ctx, "SELECT 1")
+	if want := []string{"down", "primary", "primary"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRouterStmtCache(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+
+	var got []string
+	router := sqlb.NewRouter(routeRecorder("primary", &got, nil), []sqlb.DB{routeRecorder("replica", &got, nil)})
+	cache := sqlb.NewStmtCache(router)
+	defer cache.Close()
+
+	// the same cached query is routed by each call's context
+	_, _ = cache.QueryContext(ctx, "SELECT 1")
+	_, _ = cache.QueryContext(sqlb.WithRoute(ctx, sqlb.RoutePrimary), "SELECT 1")
+	_, _ = cache.QueryContext(ctx, "SELECT 1")
+	_, _ = cache.ExecContext(ctx, "DELETE FROM users")
+
+	sticky := sqlb.WithStickyPrimary(ctx)
+	_, _ = cache.ExecContext(sticky, "DELETE FROM users")
+	_, _ = cache.QueryContext(sticky, "SELECT 1")
+
+	if want := []string{"replica", "primary", "replica", "primary", "primary", "primary"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// statements are cached for each database
+	primary, replica := newDB(ctx), newDB(ctx)
+	defer primary.Close()
+	defer replica.Close()
+	primary.SetMaxOpenConns(1)
+	replica.SetMaxOpenConns(1)
+	if err := sqlb.Exec(ctx, primary, "INSERT INTO tasks (name) VALUES (?)", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	cache = sqlb.NewStmtCache(sqlb.NewRouter(primary, []sqlb.DB{replica}))
+	defer cache.Close()
+
+	count := func(ctx context.Context) (n int) {
+		t.Helper()
+		if err := sqlb.QueryRow(ctx, cache, sqlb.Scan(&n), "SELECT count(*) FROM tasks"); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	for range 2 {
+		if n := count(ctx); n != 0 {
+			t.Errorf("got %d tasks from replica, want 0", n)
+		}
+		if n := count(sqlb.WithRoute(ctx, sqlb.RoutePrimary)); n != 1 {
+			t.Errorf("got %d tasks from primary, want 1", n)
+		}
+	}
+	if stats := cache.Stats(); stats.Size != 2 || stats.Hits != 2 {
+		t.Errorf("got %d cached statements and %d hits, want 2 and 2", stats.Size, stats.Hits)
+	}
+}
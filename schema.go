@@ -0,0 +1,235 @@
+package sqlb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var (
+	// ErrTableNotFound is returned by [ReadTable] when the table doesn't exist.
+	ErrTableNotFound = errors.New("table not found")
+	// ErrSchemaMismatch is returned by [CheckSchema] when a type's columns don't match its table.
+	ErrSchemaMismatch = errors.New("schema mismatch")
+)
+
+// Table describes a table in the live schema, see [ReadSchema].
+type Table struct {
+	Name        string
+	Columns     []Column // in table order
+	Indexes     []Index  // ordered by name
+	ForeignKeys []ForeignKey
+}
+
+// Column returns the column with name, or nil if there isn't one.
+func (t *Table) Column(name string) *Column {
+	for i := range t.Columns {
+		if t.Columns[i].Name == name {
+			return &t.Columns[i]
+		}
+	}
+	return nil
+}
+
+// Column describes a column of a [Table].
+type Column struct {
+	Name       string
+	Type       string // as declared, which may be empty in SQLite
+	NotNull    bool
+	Default    *string // the default as an SQL expression, or nil for none
+	PrimaryKey int     // 1-based position in the primary key, or 0 if not part of it
+}
+
+// Index describes an index of a [Table], including those created implicitly for PRIMARY KEY and UNIQUE constraints.
+type Index struct {
+	Name    string
+	Unique  bool
+	Partial bool     // has a WHERE clause
+	Columns []string // empty strings for expressions
+}
+
+// ForeignKey describes a foreign key constraint of a [Table].
+type ForeignKey struct {
+	Columns    []string
+	RefTable   string
+	RefColumns []string // empty when referencing the primary key implicitly
+	OnUpdate   string   // for example "CASCADE" or "NO ACTION"
+	OnDelete   string
+}
+
+// ReadSchema reads the tables of the database into Go values, ordered by name, for checking types against the database
+// or for tools such as code generators. Internal tables are skipped. Only [DialectSQLite] is supported.
+func ReadSchema(ctx context.Context, db QueryDB, d Dialect) ([]Table, error) {
+	if err := checkSchemaDialect(d); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	if err := QueryRows(ctx, db, AppendValue(&names), `SELECT name FROM sqlite_schema WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`); err != nil {
+		return nil, fmt.Errorf("read tables: %w", err)
+	}
+
+	tables := make([]Table, 0, len(names))
+	for _, name := range names {
+		table, err := readSQLiteTable(ctx, db, name)
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, *table)
+	}
+	return tables, nil
+}
+
+// ReadTable reads a single table, like [ReadSchema]. It returns an error wrapping [ErrTableNotFound] if the table doesn't exist.
+func ReadTable(ctx context.Context, db QueryDB, d Dialect, name string) (*Table, error) {
+	if err := checkSchemaDialect(d); err != nil {
+		return nil, err
+	}
+	return readSQLiteTable(ctx, db, name)
+}
+
+// SchemaCheck pairs an [Insertable] type with the table it is stored in, see [CheckSchema].
+type SchemaCheck struct {
+	Table string
+	Item  Insertable // typically a zero value of the type
+}
+
+// CheckSchema compares the columns from each check's [Insertable.Values] with the columns of its table, so that a type
+// which has drifted from the database is found at startup rather than by a failing query. Columns the type has but the
+// table doesn't are missing, and columns the table has but the type doesn't are extra. Names are compared
+// case-insensitively for SQLite, like its identifiers.
+// It returns an error for every mismatched table joined together, each wrapping [ErrSchemaMismatch] or the error
+// reading the table, such as [ErrTableNotFound]. It is suitable for a readiness probe or a test.
+func CheckSchema(ctx context.Context, db QueryDB, d Dialect, checks ...SchemaCheck) error {
+	var errs []error
+	for _, c := range checks {
+		table, err := ReadTable(ctx, db, d, c.Table)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%T: %w", c.Item, err))
+			continue
+		}
+
+		values := c.Item.Values()
+		var missing, extra []string
+		for _, v := range values {
+			if !slices.ContainsFunc(table.Columns, func(col Column) bool { return sameName(d, v.Name, col.Name) }) {
+				missing = append(missing, v.Name)
+			}
+		}
+		for _, col := range table.Columns {
+			if !slices.ContainsFunc(values, func(v sql.NamedArg) bool { return sameName(d, v.Name, col.Name) }) {
+				extra = append(extra, col.Name)
+			}
+		}
+		if len(missing) == 0 && len(extra) == 0 {
+			continue
+		}
+
+		var details []string
+		if len(missing) > 0 {
+			details = append(details, "missing columns "+strings.Join(missing, ", "))
+		}
+		if len(extra) > 0 {
+			details = append(details, "extra columns "+strings.Join(extra, ", "))
+		}
+		errs = append(errs, fmt.Errorf("%T: table %q: %w: %s", c.Item, c.Table, ErrSchemaMismatch, strings.Join(details, "; ")))
+	}
+	return errors.Join(errs...)
+}
+
+// sameName reports whether two identifiers name the same thing. SQLite compares them case-insensitively.
+func sameName(d Dialect, a, b string) bool {
+	if d == DialectSQLite {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+func checkSchemaDialect(d Dialect) error {
+	if d != DialectSQLite {
+		return fmt.Errorf("read schema for %s: %w", d, errors.ErrUnsupported)
+	}
+	return nil
+}
+
+func readSQLiteTable(ctx context.Context, db QueryDB, name string) (*Table, error) {
+	table := &Table{Name: name}
+
+	var columns []sqliteColumn
+	if err := QueryRows(ctx, db, Append(&columns), `SELECT name, type, "notnull", dflt_value, pk FROM pragma_table_info(?) ORDER BY cid`, name); err != nil {
+		return nil, fmt.Errorf("table %q: read columns: %w", name, err)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table %q: %w", name, ErrTableNotFound)
+	}
+	for _, c := range columns {
+		table.Columns = append(table.Columns, Column(c))
+	}
+
+	var indexes []sqliteIndex
+	if err := QueryRows(ctx, db, Append(&indexes), `SELECT name, "unique", partial FROM pragma_index_list(?) ORDER BY name`, name); err != nil {
+		return nil, fmt.Errorf("table %q: read indexes: %w", name, err)
+	}
+	for _, idx := range indexes {
+		var cols []sql.NullString
+		if err := QueryRows(ctx, db, AppendValue(&cols), `SELECT name FROM pragma_index_info(?) ORDER BY seqno`, idx.Name); err != nil {
+			return nil, fmt.Errorf("table %q: index %q: read columns: %w", name, idx.Name, err)
+		}
+		for _, c := range cols {
+			idx.Columns = append(idx.Columns, c.String)
+		}
+		table.Indexes = append(table.Indexes, Index(idx))
+	}
+
+	var fks []sqliteForeignKey
+	if err := QueryRows(ctx, db, Append(&fks), `SELECT id, "table", "from", "to", on_update, on_delete FROM pragma_foreign_key_list(?) ORDER BY id, seq`, name); err != nil {
+		return nil, fmt.Errorf("table %q: read foreign keys: %w", name, err)
+	}
+	for i, fk := range fks {
+		// each row is one column of a constraint, so group rows by id
+		if i == 0 || fk.id != fks[i-1].id {
+			table.ForeignKeys = append(table.ForeignKeys, ForeignKey{RefTable: fk.refTable, OnUpdate: fk.onUpdate, OnDelete: fk.onDelete})
+		}
+		last := &table.ForeignKeys[len(table.ForeignKeys)-1]
+		last.Columns = append(last.Columns, fk.from)
+		if fk.to.Valid {
+			last.RefColumns = append(last.RefColumns, fk.to.String)
+		}
+	}
+	return table, nil
+}
+
+type sqliteColumn Column
+
+func (c *sqliteColumn) ScanFrom(columns []string, rows *sql.Rows, buf []any) error {
+	var def sql.NullString
+	if err := rows.Scan(&c.Name, &c.Type, &c.NotNull, &def, &c.PrimaryKey); err != nil {
+		return err
+	}
+	if def.Valid {
+		c.Default = &def.String
+	}
+	return nil
+}
+
+type sqliteIndex Index
+
+func (idx *sqliteIndex) ScanFrom(columns []string, rows *sql.Rows, buf []any) error {
+	return rows.Scan(&idx.Name, &idx.Unique, &idx.Partial)
+}
+
+type sqliteForeignKey struct {
+	id       int
+	refTable string
+	from     string
+	to       sql.NullString
+	onUpdate string
+	onDelete string
+}
+
+func (fk *sqliteForeignKey) ScanFrom(columns []string, rows *sql.Rows, buf []any) error {
+	return rows.Scan(&fk.id, &fk.refTable, &fk.from, &fk.to, &fk.onUpdate, &fk.onDelete)
+}
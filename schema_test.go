@@ -0,0 +1,157 @@
+package sqlb_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"go.senan.xyz/sqlb"
+)
+
+func ExampleReadSchema() {
+	ctx := context.Background()
+	db := newDB(ctx)
+	defer db.Close()
+
+	tables, _ := sqlb.ReadSchema(ctx, db, sqlb.DialectSQLite)
+	for _, t := range tables {
+		for _, c := range t.Columns {
+			fmt.Println(t.Name, c.Name, c.Type, c.NotNull, c.PrimaryKey)
+		}
+	}
+	// Output:
+	// books id INTEGER false 1
+	// books details json false 0
+	// tasks id INTEGER false 1
+	// tasks name TEXT true 0
+	// tasks age INTEGER true 0
+}
+
+func TestReadTable(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	for _, q := range []string{
+		`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT NOT NULL UNIQUE, created TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`,
+		`CREATE TABLE memberships (
+			user_id INTEGER NOT NULL REFERENCES users ON DELETE CASCADE,
+			task_id INTEGER NOT NULL,
+			task_name TEXT NOT NULL,
+			role TEXT NOT NULL DEFAULT 'member',
+			PRIMARY KEY (user_id, task_id),
+			FOREIGN KEY (task_id, task_name) REFERENCES tasks (id, name) ON UPDATE CASCADE
+		)`,
+		`CREATE INDEX memberships_role ON memberships (role) WHERE role != 'member'`,
+		`CREATE INDEX memberships_lower ON memberships (lower(task_name), task_id)`,
+	} {
+		if err := sqlb.Exec(ctx, db, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	table, err := sqlb.ReadTable(ctx, db, sqlb.DialectSQLite, "memberships")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, c := range table.Columns {
+		def := "<nil>"
+		if c.Default != nil {
+			def = *c.Default
+		}
+		got = append(got, fmt.Sprint(c.Name, " ", c.Type, " ", c.NotNull, " ", def, " ", c.PrimaryKey))
+	}
+	for _, idx := range table.Indexes {
+		got = append(got, fmt.Sprintf("index %s %t %t %q", idx.Name, idx.Unique, idx.Partial, idx.Columns))
+	}
+	for _, fk := range table.ForeignKeys {
+		got = append(got, fmt.Sprintf("fk %q %s %q %s %s", fk.Columns, fk.RefTable, fk.RefColumns, fk.OnUpdate, fk.OnDelete))
+	}
+	want := []string{
+		"user_id INTEGER true <nil> 1",
+		"task_id INTEGER true <nil> 2",
+		"task_name TEXT true <nil> 0",
+		"role TEXT true 'member' 0",
+		`index memberships_lower false false ["" "task_id"]`,
+		`index memberships_role false true ["role"]`,
+		`index sqlite_autoindex_memberships_1 true false ["user_id" "task_id"]`,
+		`fk ["task_id" "task_name"] tasks ["id" "name"] CASCADE NO ACTION`,
+		`fk ["user_id"] users [] NO ACTION CASCADE`,
+	}
+	if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", want) {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	if c := table.Column("role"); c == nil || c.Name != "role" {
+		t.Errorf("got column %v, want role", c)
+	}
+	if c := table.Column("nope"); c != nil {
+		t.Errorf("got column %v, want nil", c)
+	}
+
+	if _, err := sqlb.ReadTable(ctx, db, sqlb.DialectSQLite, "nope"); !errors.Is(err, sqlb.ErrTableNotFound) {
+		t.Errorf("got %v, want %v", err, sqlb.ErrTableNotFound)
+	}
+	if _, err := sqlb.ReadSchema(ctx, db, sqlb.DialectPostgres); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("got %v, want %v", err, errors.ErrUnsupported)
+	}
+}
+
+func ExampleCheckSchema() {
+	ctx := context.Background()
+	db := newDB(ctx)
+	defer db.Close()
+
+	err := sqlb.CheckSchema(ctx, db, sqlb.DialectSQLite, sqlb.SchemaCheck{Table: "tasks", Item: Task{}})
+	fmt.Println(err)
+	// Output:
+	// <nil>
+}
+
+type taskDrifted struct{}
+
+func (taskDrifted) IsGenerated(string) bool { return false }
+
+func (taskDrifted) Values() []sql.NamedArg {
+	return []sql.NamedArg{sql.Named("id", 0), sql.Named("title", ""), sql.Named("done", false)}
+}
+
+// taskUpper names the columns of tasks in a different case, which SQLite treats the same.
+type taskUpper struct{}
+
+func (taskUpper) IsGenerated(string) bool { return false }
+
+func (taskUpper) Values() []sql.NamedArg {
+	return []sql.NamedArg{sql.Named("ID", 0), sql.Named("Name", ""), sql.Named("AGE", 0)}
+}
+
+func TestCheckSchema(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+
+	err := sqlb.CheckSchema(ctx, db, sqlb.DialectSQLite,
+		sqlb.SchemaCheck{Table: "tasks", Item: Task{}},
+		sqlb.SchemaCheck{Table: "tasks", Item: taskUpper{}},
+		sqlb.SchemaCheck{Table: "tasks", Item: taskDrifted{}},
+		sqlb.SchemaCheck{Table: "nope", Item: Task{}},
+	)
+	if !errors.Is(err, sqlb.ErrSchemaMismatch) {
+		t.Errorf("got %v, want %v", err, sqlb.ErrSchemaMismatch)
+	}
+	if !errors.Is(err, sqlb.ErrTableNotFound) {
+		t.Errorf("got %v, want %v", err, sqlb.ErrTableNotFound)
+	}
+	want := `sqlb_test.taskDrifted: table "tasks": schema mismatch: missing columns title, done; extra columns name, age
+sqlb_test.Task: table "nope": table not found`
+	if err == nil || err.Error() != want {
+		t.Errorf("got\n%v\nwant\n%s", err, want)
+	}
+}
@@ -0,0 +1,183 @@
+package sqlb
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SlowQueryLog flags queries slower than a threshold and captures their query plan, reported through
+// [LogEvent.Slow] and [LogEvent.Plan]. Plans are captured with EXPLAIN QUERY PLAN for SQLite and EXPLAIN otherwise,
+// at most once per Interval for each query [Fingerprint]. They are captured in the same transaction as the query, or on
+// a single connection from the same pool, bypassing any [StmtCache] so EXPLAIN statements aren't cached.
+type SlowQueryLog struct {
+	Threshold time.Duration
+	Dialect   Dialect
+	Interval  time.Duration
+
+	mu    sync.Mutex
+	last  map[string]time.Time
+	swept time.Time
+}
+
+// NewSlowQueryLog creates a [SlowQueryLog] for queries slower than threshold, capturing plans at most once a minute per query fingerprint.
+func NewSlowQueryLog(threshold time.Duration, dialect Dialect) *SlowQueryLog {
+	return &SlowQueryLog{
+		Threshold: threshold,
+		Dialect:   dialect,
+		Interval:  time.Minute,
+	}
+}
+
+const explainTimeout = 5 * time.Second
+
+type slowQueryLogContextKey struct{}
+
+// WithSlowQueryLog returns a context that will flag slow queries in log events.
+// It has no effect unless a log func is also set with [WithLogEventFunc] or [WithLogFunc].
+func WithSlowQueryLog(ctx context.Context, s *SlowQueryLog) context.Context {
+	return context.WithValue(ctx, slowQueryLogContextKey{}, s)
+}
+
+func slowQueryLog(ctx context.Context) *SlowQueryLog {
+	s, _ := ctx.Value(slowQueryLogContextKey{}).(*SlowQueryLog)
+	return s
+}
+
+// allow reports whether a plan should be captured for a query fingerprint, recording the attempt if so.
+func (s *SlowQueryLog) allow(fingerprint string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.last == nil {
+		s.last = make(map[string]time.Time)
+	}
+	if now.Sub(s.swept) >= s.Interval {
+		// forget fingerprints that are no longer rate limited, so the map doesn't grow with every query seen
+		for fp, last := range s.last {
+			if now.Sub(last) >= s.Interval {
+				delete(s.last, fp)
+			}
+		}
+		s.swept = now
+	}
+
+	if last, ok := s.last[fingerprint]; ok && now.Sub(last) < s.Interval {
+		return false
+	}
+	s.last[fingerprint] = now
+	return true
+}
+
+// explain captures the plan for query, or returns "" if rate limited or db can't run queries.
+// Errors are reported in place of the plan, since the log event is the only place to surface them.
+func (s *SlowQueryLog) explain(ctx context.Context, db any, query, fingerprint string, args []any) string {
+	if !s.allow(fingerprint) {
+		return ""
+	}
+
+	// the original query may have been slow because its context expired, and the explain shouldn't
+	// be attributed to the original operation's log event, cache stats, or middleware
+	ctx = context.WithValue(context.WithoutCancel(ctx), loggerContextKey{}, (*logger)(nil))
+	ctx = context.WithValue(ctx, rowsDoneContextKey{}, (*rowsDone)(nil))
+	ctx, cancel := context.WithTimeout(ctx, explainTimeout)
+	defer cancel()
+
+	prefix := "EXPLAIN "
+	if s.Dialect == DialectSQLite {
+		prefix = "EXPLAIN QUERY PLAN "
+	}
+
+	qdb, release, err := explainDB(ctx, db)
+	if err != nil {
+		return "explain: " + err.Error()
+	}
+	if qdb == nil {
+		return ""
+	}
+	defer release()
+
+	rows, err := qdb.QueryContext(ctx, prefix+query, args...)
+	if err != nil {
+		return "explain: " + err.Error()
+	}
+	defer rows.Close()
+
+	plan, err := readPlan(rows, s.Dialect)
+	if err != nil {
+		return "explain: " + err.Error()
+	}
+	return plan
+}
+
+// explainDB returns the handle to explain a query from db on, and a func to release it. It is nil if db can't run queries.
+func explainDB(ctx context.Context, db any) (QueryDB, func(), error) {
+	switch db := db.(type) {
+	case *StmtCache:
+		return explainDB(ctx, db.db)
+	case *TxStmtCache:
+		return explainDB(ctx, db.tx)
+	case *MiddlewareDB:
+		return explainDB(ctx, db.db)
+	case interface {
+		Conn(ctx context.Context) (*sql.Conn, error)
+	}:
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		return conn, func() { _ = conn.Close() }, nil
+	case QueryDB:
+		return db, func() {}, nil
+	default:
+		return nil, nil, nil
+	}
+}
+
+func readPlan(rows *sql.Rows, dialect Dialect) (string, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+
+	var lines []string
+	depths := map[int64]int{}
+	for rows.Next() {
+		// SQLite's plan is a tree of (id, parent, notused, detail) rows, indent it by depth
+		if dialect == DialectSQLite && len(columns) == 4 {
+			var id, parent, notused int64
+			var detail string
+			if err := rows.Scan(&id, &parent, &notused, &detail); err != nil {
+				return "", err
+			}
+			depth := 0
+			if d, ok := depths[parent]; ok {
+				depth = d + 1
+			}
+			depths[id] = depth
+			lines = append(lines, strings.Repeat("  ", depth)+detail)
+			continue
+		}
+
+		values := make([]sql.NullString, len(columns))
+		dests := make([]any, len(columns))
+		for i := range values {
+			dests[i] = &values[i]
+		}
+		if err := rows.Scan(dests...); err != nil {
+			return "", err
+		}
+		fields := make([]string, len(values))
+		for i, v := range values {
+			fields[i] = v.String
+		}
+		lines = append(lines, strings.Join(fields, "\t"))
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	return strings.Join(lines, "\n"), nil
+}
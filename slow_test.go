@@ -0,0 +1,157 @@
+package sqlb_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"go.senan.xyz/sqlb"
+)
+
+func ExampleWithSlowQueryLog() {
+	ctx := context.Background()
+	db := newDB(ctx)
+	defer db.Close()
+
+	ctx = sqlb.WithLogEventFunc(ctx, func(ctx context.Context, ev sqlb.LogEvent) {
+		if ev.Slow {
+			fmt.Printf("slow query %q\n%s\n", ev.Query, ev.Plan)
+		}
+	})
+	ctx = sqlb.WithSlowQueryLog(ctx, sqlb.NewSlowQueryLog(time.Nanosecond, sqlb.DialectSQLite))
+
+	var tasks []Task
+	_ = sqlb.QueryRows(ctx, db, sqlb.Append(&tasks), "SELECT * FROM tasks WHERE age > ?", 18)
+	// Output:
+	// slow query "SELECT * FROM tasks WHERE age > ?"
+	// SCAN tasks
+}
+
+func TestSlowQueryLog(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+
+	var events []sqlb.LogEvent
+	ctx = sqlb.WithLogEventFunc(ctx, func(ctx context.Context, ev sqlb.LogEvent) {
+		events = append(events, ev)
+	})
+
+	slow := sqlb.NewSlowQueryLog(time.Nanosecond, sqlb.DialectSQLite)
+	ctx = sqlb.WithSlowQueryLog(ctx, slow)
+
+	var x int
+	for range 2 {
+		_ = sqlb.QueryRow(ctx, db, sqlb.Scan(&x), "SELECT id FROM tasks WHERE id IN (SELECT id FROM tasks WHERE name = ?)", "a")
+	}
+	if err := sqlb.Exec(ctx, db, "UPDATE tasks SET age = ? WHERE id = ?", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3", len(events))
+	}
+	for _, ev := range events {
+		if !ev.Slow {
+			t.Errorf("expected slow event: %+v", ev)
+		}
+	}
+	if !strings.Contains(events[0].Plan, "SEARCH tasks") || !strings.Contains(events[0].Plan, "\n  ") {
+		t.Errorf("unexpected nested plan: %q", events[0].Plan)
+	}
+	if events[1].Plan != "" {
+		t.Errorf("expected plan to be rate limited, got %q", events[1].Plan)
+	}
+	if !strings.Contains(events[2].Plan, "SEARCH tasks USING INTEGER PRIMARY KEY") {
+		t.Errorf("unexpected exec plan: %q", events[2].Plan)
+	}
+}
+
+func TestSlowQueryLogThreshold(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+
+	var events []sqlb.LogEvent
+	ctx = sqlb.WithLogEventFunc(ctx, func(ctx context.Context, ev sqlb.LogEvent) {
+		events = append(events, ev)
+	})
+	ctx = sqlb.WithSlowQueryLog(ctx, sqlb.NewSlowQueryLog(time.Hour, sqlb.DialectSQLite))
+
+	var x int
+	if err := sqlb.QueryRow(ctx, db, sqlb.Scan(&x), "SELECT 1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Slow || events[0].Plan != "" {
+		t.Errorf("unexpected events: %+v", events)
+	}
+}
+
+func TestSlowQueryLogStmtCache(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	cache := sqlb.NewStmtCache(db)
+	defer cache.Close()
+
+	var plans []string
+	ctx = sqlb.WithLogEventFunc(ctx, func(ctx context.Context, ev sqlb.LogEvent) {
+		if ev.Type == "query" {
+			plans = append(plans, ev.Plan)
+		}
+	})
+	// a literal, rather than from NewSlowQueryLog
+	ctx = sqlb.WithSlowQueryLog(ctx, &sqlb.SlowQueryLog{Threshold: time.Nanosecond, Dialect: sqlb.DialectSQLite})
+
+	var x int
+	for _, id := range []int{1, 2} {
+		_ = sqlb.QueryRow(ctx, cache, sqlb.Scan(&x), "SELECT id FROM tasks WHERE id = ?", id)
+	}
+
+	if len(plans) != 2 || !strings.Contains(plans[0], "SEARCH tasks") || !strings.Contains(plans[1], "SEARCH tasks") {
+		t.Errorf("unexpected plans: %q", plans)
+	}
+	// only the query itself is cached, not the EXPLAIN
+	if size := cache.Stats().Size; size != 1 {
+		t.Errorf("got %d cached statements, want 1", size)
+	}
+}
+
+func TestSlowQueryLogMiddleware(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	var started, finished int
+	mdb := sqlb.Wrap(db, func(ctx context.Context, op *sqlb.Op, next sqlb.Next) error {
+		started++
+		op.OnFinish(func(error) { finished++ })
+		return next(ctx, op)
+	})
+
+	var plan string
+	ctx = sqlb.WithLogEventFunc(ctx, func(ctx context.Context, ev sqlb.LogEvent) {
+		plan = ev.Plan
+	})
+	ctx = sqlb.WithSlowQueryLog(ctx, sqlb.NewSlowQueryLog(time.Nanosecond, sqlb.DialectSQLite))
+
+	var x int
+	_ = sqlb.QueryRow(ctx, mdb, sqlb.Scan(&x), "SELECT id FROM tasks WHERE id = ?", 1)
+
+	if !strings.Contains(plan, "SEARCH tasks") {
+		t.Errorf("unexpected plan: %q", plan)
+	}
+	// the explain doesn't run through the middleware
+	if started != 1 || finished != 1 {
+		t.Errorf("got %d operations started and %d finished, want 1 and 1", started, finished)
+	}
+}
@@ -61,12 +61,26 @@
 //	q.Append("AND role IN ?", sqlb.InSQL("editor", "admin"))  // built-in SQLer
 //	q.Append("AND ?", myCustomSQLer(x))                       // bring-your-own SQLer
 //
+// For debugging, [UnsafeInterpolate] renders a query with its arguments inlined, to paste into a database shell.
+//
 // # Code generation
 //
 // Use sqlbgen to generate [Scanner], [Insertable], and [Updatable] implementations:
 //
 //	//go:generate go tool sqlbgen type User generated ID -- user.gen.go
 //
+// # Middleware
+//
+// [Wrap] runs every operation on a handle through a chain of [Middleware], for auditing, tracing, or rewriting queries:
+//
+//	db := sqlb.Wrap(db, func(ctx context.Context, op *sqlb.Op, next sqlb.Next) error {
+//	    err := next(ctx, op)
+//	    audit(ctx, op.Type, op.Query, err)
+//	    return err
+//	})
+//
+// Use [Op.OnFinish] to observe errors from reading the rows of queries, and [Timeout] to limit how long operations take.
+//
 // # Statement caching
 //
 // [StmtCache] wraps a database connection to cache prepared statements:
@@ -75,13 +89,110 @@
 //	defer cache.Close()
 //	sqlb.QueryRows(ctx, cache, sqlb.Append(&users), "SELECT * FROM users")
 //
+// Use [StmtCache.Tx] to use the cached statements in a transaction. [StmtCache.Stats] reports hits and misses,
+// and [StmtCache.InvalidateAll] drops cached statements after migrations.
+//
+// # Transactions
+//
+// [InTx] runs a function in a transaction, rolling back on error or panic, and retrying busy errors and
+// serialization failures if configured:
+//
+//	err := sqlb.InTx(ctx, db, &sqlb.TxOptions{MaxRetries: 3}, func(ctx context.Context, tx *sqlb.Tx) error {
+//	    return sqlb.Exec(ctx, tx, "UPDATE accounts SET balance = balance - ? WHERE id = ?", amount, id)
+//	})
+//
+// [InTx] and [BeginTx] carry a [Tx] in the context, so nested calls create savepoints instead of new transactions.
+// Code anywhere under that context can use [OnCommit] and [OnRollback] to run side effects once it finishes.
+// Give layers a [Resolver] instead of a handle to have them use the transaction from context, if there is one:
+//
+//	rdb := sqlb.NewResolver(db)
+//	ctx, tx, err := sqlb.BeginTx(ctx, db, nil)
+//	err = sqlb.Exec(ctx, rdb, "DELETE FROM sessions WHERE user_id = ?", id) // runs in tx
+//
+// # Replicas
+//
+// [Router] sends reads to replicas and writes to the primary, skipping replicas that can't be reached.
+// Use [WithStickyPrimary] to read your own writes, and [WithRoute] to choose per call:
+//
+//	router := sqlb.NewRouter(primary, []sqlb.DB{replica1, replica2})
+//	ctx = sqlb.WithStickyPrimary(ctx)
+//
+// For SQLite, [OpenSQLite] opens a single writer connection and a pool of readers with the recommended pragmas,
+// routed the same way:
+//
+//	db, err := sqlb.OpenSQLite("sqlite3", "file:app.db", nil)
+//
+// # Migrations
+//
+// [Migrator] applies versioned .sql files from an [io/fs.FS], each in a transaction, refusing to run if an applied
+// migration has changed:
+//
+//	//go:embed migrations
+//	var migrations embed.FS
+//
+//	fsys, _ := fs.Sub(migrations, "migrations")
+//	m, err := sqlb.NewMigrator(db, fsys, nil)
+//	err = m.Up(ctx)
+//
+// Use [Migrator.AddFunc] for migrations that need Go code. The database is locked while migrating, so several
+// instances of an application can run Up at startup.
+//
+// # Schema
+//
+// [ReadSchema] and [ReadTable] read the live schema's tables, columns, indexes, and foreign keys into Go values:
+//
+//	table, err := sqlb.ReadTable(ctx, db, sqlb.DialectSQLite, "users")
+//
+// [CheckSchema] uses it to verify that [Insertable] types match their tables, for example at startup:
+//
+//	err := sqlb.CheckSchema(ctx, db, sqlb.DialectSQLite, sqlb.SchemaCheck{Table: "users", Item: User{}})
+//
+// # Query comments
+//
+// [WithSQLComment] tags outgoing SQL with a sqlcommenter-style comment, to correlate database logs with application code:
+//
+//	ctx = sqlb.WithSQLComment(ctx, "route", "/users")
+//	ctx = sqlb.WithSQLCommentCaller(ctx)
+//	// SELECT * FROM users /*caller='users.List',route='%2Fusers'*/
+//
+// Statements cached by [StmtCache] are prepared without the comment, so queries through a cache aren't tagged.
+//
 // # Logging
 //
-// Use [WithLogFunc] to add query logging via context:
+// Use [WithLogEventFunc] to add query logging via context. Each [LogEvent] includes the error,
+// rows read or affected, a [Fingerprint] for aggregation, and whether a [StmtCache] was used:
 //
-//	ctx := sqlb.WithLogFunc(ctx, func(ctx context.Context, typ, query string, dur time.Duration) {
-//	    slog.DebugContext(ctx, "query", "type", typ, "query", query, "dur", dur)
+//	ctx := sqlb.WithLogEventFunc(ctx, func(ctx context.Context, ev sqlb.LogEvent) {
+//	    slog.DebugContext(ctx, "query", "type", ev.Type, "query", ev.Query, "rows", ev.Rows, "err", ev.Err, "dur", ev.Dur)
 //	})
+//
+// [WithLogStartFunc] is called before execution, to observe queries while they are still running.
+// [WithLogFunc] is a simpler adapter for callbacks only interested in the query and duration.
+//
+// Use [WithSlowQueryLog] to flag slow queries in log events, along with their query plan:
+//
+//	ctx = sqlb.WithSlowQueryLog(ctx, sqlb.NewSlowQueryLog(200*time.Millisecond, sqlb.DialectSQLite))
+//
+// [WithMetrics] aggregates counts, errors, rows, and latencies per query fingerprint, exportable with expvar:
+//
+//	m := sqlb.NewMetrics()
+//	m.Publish("sqlb")
+//	ctx = sqlb.WithMetrics(ctx, m)
+//
+// Wrap arguments in [Sensitive] to keep them out of logs while still sending them to the database,
+// or use [WithRedactedColumns] to redact columns from [InsertSQL] and [UpdateSQL] by name.
+//
+// [WithTracer] starts a span for each operation, through a minimal [Tracer] interface that can be adapted to
+// a tracing library like OpenTelemetry.
+//
+// # Errors
+//
+// [ClassifyError] and predicates like [IsUniqueViolation] classify constraint violations without
+// matching driver-specific codes. SQLite, Postgres, and MySQL are built-in, others can be added with [RegisterErrorClassifier]:
+//
+//	if err := sqlb.Exec(ctx, db, "INSERT INTO users ?", sqlb.InsertSQL(user)); sqlb.IsUniqueViolation(err) {
+//	    return fmt.Errorf("%s already taken", sqlb.ConstraintName(err))
+//	}
 package sqlb
 
 import (
@@ -92,9 +203,11 @@ import (
 	"errors"
 	"fmt"
 	"iter"
+	"runtime"
 	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -102,6 +215,7 @@ import (
 type Query struct {
 	query    *strings.Builder
 	args     []any
+	names    []string // column name per arg, if any came from an [Insertable] or [Updatable]
 	lastByte byte
 }
 
@@ -132,11 +246,28 @@ func (q *Query) Append(query string, args ...any) {
 		q.lastByte = query[len(query)-1]
 	}
 	q.args = append(q.args, args...)
+	if q.names != nil {
+		q.names = append(q.names, make([]string, len(args))...)
+	}
 }
 
 // SQL returns the composed SQL string and flattened argument slice.
 // If any argument implements [SQLer], they are expanded recursively in-place.
 func (q Query) SQL() (string, []any) {
+	query, args, _ := q.sql(false)
+	return query, args
+}
+
+// nameLast records the column name of the last appended argument, for [WithRedactedColumns].
+func (q *Query) nameLast(name string) {
+	if q.names == nil {
+		q.names = make([]string, len(q.args))
+	}
+	q.names[len(q.names)-1] = name
+}
+
+// sql is [Query.SQL], optionally also returning the column name of each argument, or "" if unknown.
+func (q Query) sql(withNames bool) (string, []any, []string) {
 	// fast path
 	var hasSQLer bool
 	for _, a := range q.args {
@@ -146,11 +277,15 @@ func (q Query) SQL() (string, []any) {
 		}
 	}
 	if !hasSQLer {
-		return q.query.String(), q.args
+		if withNames && q.names == nil {
+			return q.query.String(), q.args, make([]string, len(q.args))
+		}
+		return q.query.String(), q.args, q.names
 	}
 
 	var query strings.Builder
 	var args []any
+	var names []string
 
 	var count int
 	for _, c := range q.query.String() {
@@ -160,19 +295,34 @@ func (q Query) SQL() (string, []any) {
 		}
 
 		switch arg := q.args[count].(type) {
+		case Query:
+			q, ar, nm := arg.sql(withNames)
+			query.WriteString(q)
+			args = append(args, ar...)
+			names = append(names, nm...)
 		case SQLer:
 			q, ar := arg.SQL()
 			query.WriteString(q)
 			args = append(args, ar...)
+			if withNames {
+				names = append(names, make([]string, len(ar))...)
+			}
 		default:
 			query.WriteRune(c)
 			args = append(args, arg)
+			if withNames {
+				var name string
+				if q.names != nil {
+					name = q.names[count]
+				}
+				names = append(names, name)
+			}
 		}
 
 		count++
 	}
 
-	return query.String(), args
+	return query.String(), args, names
 }
 
 // SQLer is implemented by types that can be embedded as query arguments.
@@ -200,6 +350,7 @@ func UpdateSQL(item Updatable) SQLer {
 			p = ", "
 		}
 		b.Append(p+v.Name+"=?", v.Value)
+		b.nameLast(v.Name)
 		set = true
 	}
 	return b
@@ -235,20 +386,24 @@ func InsertSQL[T Insertable](items ...T) SQLer {
 
 	rows := make([]string, len(items))
 	values := make([]any, 0, len(columns)*len(items))
+	names := make([]string, 0, len(columns)*len(items))
 	for i, item := range items {
 		for _, v := range item.Values() {
 			if item.IsGenerated(v.Name) {
 				continue
 			}
 			values = append(values, v.Value)
+			names = append(names, v.Name)
 		}
 		rows[i] = rowPlaceholder
 	}
 
-	return NewQuery(
+	q := NewQuery(
 		fmt.Sprintf("(%s) VALUES %s", strings.Join(columns, ", "), strings.Join(rows, ", ")),
 		values...,
 	)
+	q.names = names
+	return q
 }
 
 // InSQL builds a [SQLer] for an IN clause or value tuple, e.g. (?, ?, ?).
@@ -289,12 +444,17 @@ type QueryDB interface {
 // QueryRow executes the query and reads the first row into dest, which is typically
 // a native [Scanner] type or one created with a [Scanner] helper such as [Scan].
 // Returns [sql.ErrNoRows] if no rows are found.
-func QueryRow(ctx context.Context, db QueryDB, dest Scanner, query string, args ...any) error {
-	query, args = NewQuery(query, args...).SQL()
+func QueryRow(ctx context.Context, db QueryDB, dest Scanner, query string, args ...any) (err error) {
+	q := NewQuery(query, args...)
+	query, args = q.SQL()
+	ctx, query = appendSQLComment(ctx, query)
 
-	if lf := logFunc(ctx); lf != nil {
-		defer log(ctx, lf, "query", query)()
-	}
+	ctx, lg := logStart(ctx, db, "query", query, args, q)
+	var n int64
+	defer func() { lg.finish(ctx, err, n) }()
+
+	ctx, rd := withRowsDone(ctx)
+	defer func() { rd.finish(err) }()
 
 	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -318,17 +478,23 @@ func QueryRow(ctx context.Context, db QueryDB, dest Scanner, query string, args
 	if err := dest.ScanFrom(columns, rows, buf); err != nil {
 		return err
 	}
+	n = 1
 	return nil
 }
 
 // QueryRows executes the query and reads all rows into dest, which is typically
 // a native [Scanner] type or one created with a [Scanner] helper like [Append].
-func QueryRows(ctx context.Context, db QueryDB, dest Scanner, query string, args ...any) error {
-	query, args = NewQuery(query, args...).SQL()
+func QueryRows(ctx context.Context, db QueryDB, dest Scanner, query string, args ...any) (err error) {
+	q := NewQuery(query, args...)
+	query, args = q.SQL()
+	ctx, query = appendSQLComment(ctx, query)
 
-	if lf := logFunc(ctx); lf != nil {
-		defer log(ctx, lf, "query", query)()
-	}
+	ctx, lg := logStart(ctx, db, "query", query, args, q)
+	var n int64
+	defer func() { lg.finish(ctx, err, n) }()
+
+	ctx, rd := withRowsDone(ctx)
+	defer func() { rd.finish(err) }()
 
 	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -346,6 +512,7 @@ func QueryRows(ctx context.Context, db QueryDB, dest Scanner, query string, args
 		if err := dest.ScanFrom(columns, rows, buf[:0]); err != nil {
 			return err
 		}
+		n++
 	}
 	return rows.Err()
 }
@@ -354,14 +521,21 @@ func QueryRows(ctx context.Context, db QueryDB, dest Scanner, query string, args
 // T must implement [Scanner] via its pointer type.
 func Rows[T any, pT ScannerPtr[T]](ctx context.Context, db QueryDB, query string, args ...any) iter.Seq2[T, error] {
 	return func(yield func(T, error) bool) {
-		query, args = NewQuery(query, args...).SQL()
+		q := NewQuery(query, args...)
+		query, args := q.SQL()
+		ctx, query := appendSQLComment(ctx, query)
 
-		if lf := logFunc(ctx); lf != nil {
-			defer log(ctx, lf, "query", query)()
-		}
+		ctx, lg := logStart(ctx, db, "query", query, args, q)
+		var lerr error
+		var n int64
+		defer func() { lg.finish(ctx, lerr, n) }()
+
+		ctx, rd := withRowsDone(ctx)
+		defer func() { rd.finish(lerr) }()
 
 		rows, err := db.QueryContext(ctx, query, args...)
 		if err != nil {
+			lerr = err
 			var zero T
 			yield(zero, err)
 			return
@@ -370,6 +544,7 @@ func Rows[T any, pT ScannerPtr[T]](ctx context.Context, db QueryDB, query string
 
 		columns, err := rows.Columns()
 		if err != nil {
+			lerr = err
 			var zero T
 			yield(zero, err)
 			return
@@ -379,17 +554,20 @@ func Rows[T any, pT ScannerPtr[T]](ctx context.Context, db QueryDB, query string
 		for rows.Next() {
 			var t T
 			if err := pT(&t).ScanFrom(columns, rows, buf[:0]); err != nil {
+				lerr = err
 				var zero T
 				if !yield(zero, err) {
 					return
 				}
 				continue
 			}
+			n++
 			if !yield(t, nil) {
 				return
 			}
 		}
 		if err := rows.Err(); err != nil {
+			lerr = err
 			var zero T
 			yield(zero, err)
 			return
@@ -401,14 +579,21 @@ func Rows[T any, pT ScannerPtr[T]](ctx context.Context, db QueryDB, query string
 // Unlike [Rows], it reuses the same dest each iteration, suitable for use with [Scanner] helpers like [Scan].
 func Each(ctx context.Context, db QueryDB, dest Scanner, query string, args ...any) iter.Seq[error] {
 	return func(yield func(error) bool) {
-		query, args = NewQuery(query, args...).SQL()
+		q := NewQuery(query, args...)
+		query, args := q.SQL()
+		ctx, query := appendSQLComment(ctx, query)
 
-		if lf := logFunc(ctx); lf != nil {
-			defer log(ctx, lf, "query", query)()
-		}
+		ctx, lg := logStart(ctx, db, "query", query, args, q)
+		var lerr error
+		var n int64
+		defer func() { lg.finish(ctx, lerr, n) }()
+
+		ctx, rd := withRowsDone(ctx)
+		defer func() { rd.finish(lerr) }()
 
 		rows, err := db.QueryContext(ctx, query, args...)
 		if err != nil {
+			lerr = err
 			yield(err)
 			return
 		}
@@ -416,6 +601,7 @@ func Each(ctx context.Context, db QueryDB, dest Scanner, query string, args ...a
 
 		columns, err := rows.Columns()
 		if err != nil {
+			lerr = err
 			yield(err)
 			return
 		}
@@ -423,16 +609,19 @@ func Each(ctx context.Context, db QueryDB, dest Scanner, query string, args ...a
 		buf := make([]any, 0, len(columns))
 		for rows.Next() {
 			if err := dest.ScanFrom(columns, rows, buf[:0]); err != nil {
+				lerr = err
 				if !yield(err) {
 					return
 				}
 				continue
 			}
+			n++
 			if !yield(nil) {
 				return
 			}
 		}
 		if err := rows.Err(); err != nil {
+			lerr = err
 			yield(err)
 			return
 		}
@@ -444,16 +633,32 @@ type ExecDB interface {
 	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
 }
 
+// DB is an interface compatible with [*sql.DB], [*sql.Tx], [*sql.Conn], [*StmtCache], or [*TxStmtCache] for querying and executing.
+type DB interface {
+	QueryDB
+	ExecDB
+}
+
 // Exec executes a query without returning any rows.
-func Exec(ctx context.Context, db ExecDB, query string, args ...any) error {
-	query, args = NewQuery(query, args...).SQL()
+func Exec(ctx context.Context, db ExecDB, query string, args ...any) (err error) {
+	q := NewQuery(query, args...)
+	query, args = q.SQL()
+	ctx, query = appendSQLComment(ctx, query)
 
-	if lf := logFunc(ctx); lf != nil {
-		defer log(ctx, lf, "exec", query)()
-	}
+	ctx, lg := logStart(ctx, db, "exec", query, args, q)
+	n := int64(-1)
+	defer func() { lg.finish(ctx, err, n) }()
 
-	_, err := db.ExecContext(ctx, query, args...)
-	return err
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if lg != nil {
+		if ra, err := res.RowsAffected(); err == nil {
+			n = ra
+		}
+	}
+	return nil
 }
 
 // Append returns a [Scanner] that appends each row to dest.
@@ -588,25 +793,177 @@ func (j JSON[T]) Value() (driver.Value, error) {
 	return json.Marshal(j.Data)
 }
 
+type jsonValuer interface {
+	driver.Valuer
+	jsonValue()
+}
+
+func (j JSON[T]) jsonValue() {}
+
 // LogFunc is a callback for logging query execution.
 type LogFunc = func(ctx context.Context, typ string, query string, dur time.Duration)
 
-type logFuncContextKey struct{}
-
 // WithLogFunc returns a context that will log queries using the provided function.
+// It is an adapter for [WithLogEventFunc] that ignores statement preparation.
 func WithLogFunc(ctx context.Context, lf LogFunc) context.Context {
-	return context.WithValue(ctx, logFuncContextKey{}, lf)
+	return WithLogEventFunc(ctx, func(ctx context.Context, ev LogEvent) {
+		if ev.Type == "prepare" {
+			return
+		}
+		lf(ctx, ev.Type, ev.Query, ev.Dur)
+	})
+}
+
+// LogEvent describes a query or exec. Events passed to a start func only have the fields known
+// before execution: ID, Type, Query, Args, Fingerprint, Caller, and Start.
+type LogEvent struct {
+	ID          uint64 // unique per operation, shared by the start and finish events
+	Type        string // "query", "exec", or "prepare" for statements prepared by [StmtCache], see [WithLogPrepares]
+	Query       string
+	Args        []any  // with [Sensitive] values and [WithRedactedColumns] columns formatted as <redacted>
+	Fingerprint string // the [Fingerprint] of Query, for aggregating queries with the same shape
+	Err         error
+	Rows        int64  // rows read for queries, rows affected for execs, or -1 if unknown
+	Cached      bool   // whether the statement was served by a [StmtCache]
+	Hit         bool   // whether the [StmtCache] already had the statement prepared
+	Caller      string // the function that called into sqlb, e.g. "users.(*Store).List"
+	Start       time.Time
+	Dur         time.Duration
+	Slow        bool   // whether Dur exceeded the [SlowQueryLog] threshold
+	Plan        string // the query plan captured for slow queries, if not rate limited
+}
+
+// LogEventFunc is a callback for structured logging of query execution.
+type LogEventFunc = func(ctx context.Context, ev LogEvent)
+
+type logEventFuncContextKey struct{}
+
+// WithLogEventFunc returns a context that will log queries using the provided function once they finish.
+func WithLogEventFunc(ctx context.Context, lf LogEventFunc) context.Context {
+	return context.WithValue(ctx, logEventFuncContextKey{}, lf)
+}
+
+func logEventFunc(ctx context.Context) LogEventFunc {
+	f, _ := ctx.Value(logEventFuncContextKey{}).(LogEventFunc)
+	return f
+}
+
+type logPreparesContextKey struct{}
+
+// WithLogPrepares returns a context where log funcs also receive events of type "prepare" for statements
+// prepared by [StmtCache]. They are always recorded by [WithMetrics] and [WithTracer].
+func WithLogPrepares(ctx context.Context) context.Context {
+	return context.WithValue(ctx, logPreparesContextKey{}, true)
+}
+
+type logStartFuncContextKey struct{}
+
+// WithLogStartFunc returns a context that will call the provided function before each query is sent to
+// the database, so that long-running queries can be observed while still running. Pair it with
+// [WithLogEventFunc] and match events by [LogEvent.ID] to track queries in flight.
+func WithLogStartFunc(ctx context.Context, lf LogEventFunc) context.Context {
+	return context.WithValue(ctx, logStartFuncContextKey{}, lf)
 }
 
-func logFunc(ctx context.Context) LogFunc {
-	f, _ := ctx.Value(logFuncContextKey{}).(LogFunc)
+func logStartFunc(ctx context.Context) LogEventFunc {
+	f, _ := ctx.Value(logStartFuncContextKey{}).(LogEventFunc)
 	return f
 }
 
-func log(ctx context.Context, lf LogFunc, typ string, query string) func() {
-	start := time.Now()
-	return func() {
-		lf(ctx, typ, query, time.Since(start))
+var logEventID atomic.Uint64
+
+type logger struct {
+	lf      LogEventFunc
+	ev      LogEvent
+	db      any
+	args    []any // unredacted, for explain
+	slow    *SlowQueryLog
+	metrics *Metrics
+	span    Span
+}
+
+type loggerContextKey struct{}
+
+// logStart begins logging and tracing an operation, returning a nil logger if both are disabled.
+// The returned context carries the logger so that [StmtCache] can annotate the event.
+func logStart(ctx context.Context, db any, typ string, query string, args []any, q Query) (context.Context, *logger) {
+	lf, sf, m, t := logEventFunc(ctx), logStartFunc(ctx), metrics(ctx), tracer(ctx)
+	if typ == "prepare" && ctx.Value(logPreparesContextKey{}) == nil {
+		lf, sf = nil, nil
+	}
+	if lf == nil && sf == nil && m == nil && t == nil {
+		return ctx, nil
+	}
+	lg := &logger{
+		lf:      lf,
+		ev:      LogEvent{ID: logEventID.Add(1), Type: typ, Query: query, Args: redactArgs(ctx, args, q), Fingerprint: Fingerprint(query), Rows: -1, Caller: caller(), Start: time.Now()},
+		db:      db,
+		args:    args,
+		slow:    slowQueryLog(ctx),
+		metrics: m,
+	}
+	if t != nil {
+		ctx, lg.span = t.StartSpan(ctx, typ,
+			SpanAttr{"db.statement", query},
+			SpanAttr{"db.operation", operation(lg.ev.Fingerprint)},
+		)
+	}
+	if sf != nil {
+		sf(ctx, lg.ev)
+	}
+	return context.WithValue(ctx, loggerContextKey{}, lg), lg
+}
+
+func (lg *logger) finish(ctx context.Context, err error, rows int64) {
+	if lg == nil {
+		return
+	}
+	lg.ev.Err = err
+	lg.ev.Rows = rows
+	lg.ev.Dur = time.Since(lg.ev.Start)
+	if lg.span != nil {
+		lg.span.End(err, rows)
+	}
+	if lg.metrics != nil {
+		lg.metrics.observe(lg.ev)
+	}
+	if lg.lf == nil {
+		return
+	}
+	if lg.slow != nil && lg.ev.Dur > lg.slow.Threshold {
+		lg.ev.Slow = true
+		lg.ev.Plan = lg.slow.explain(ctx, lg.db, lg.ev.Query, lg.ev.Fingerprint, lg.args)
+	}
+	lg.lf(ctx, lg.ev)
+}
+
+func loggerFrom(ctx context.Context) *logger {
+	lg, _ := ctx.Value(loggerContextKey{}).(*logger)
+	return lg
+}
+
+func (lg *logger) cached(hit bool) {
+	if lg == nil {
+		return
+	}
+	lg.ev.Cached = true
+	lg.ev.Hit = hit
+}
+
+const pkgPrefix = "go.senan.xyz/sqlb."
+
+// caller returns the name of the first function on the stack outside this package.
+func caller() string {
+	var pcs [16]uintptr
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs[:])])
+	for {
+		frame, more := frames.Next()
+		if frame.Function != "" && !strings.HasPrefix(frame.Function, pkgPrefix) {
+			return frame.Function[strings.LastIndexByte(frame.Function, '/')+1:]
+		}
+		if !more {
+			return ""
+		}
 	}
 }
 
@@ -615,46 +972,174 @@ type PrepareDB interface {
 	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
 }
 
+// StmtDB is an interface compatible with [*sql.Tx] for using prepared statements in a transaction.
+type StmtDB interface {
+	PrepareDB
+	StmtContext(ctx context.Context, stmt *sql.Stmt) *sql.Stmt
+}
+
 // StmtCache wraps a database connection to cache prepared statements.
+// Comments added by [WithSQLComment] are not included in cached statements.
+// A statement that fails with a schema change error (see [IsSchemaChanged]) when executed is prepared again and
+// retried once. Errors while reading rows are returned as usual.
+//
+// When wrapping a [Router], each operation is routed as usual and statements are cached for each database.
 type StmtCache struct {
-	mu    sync.RWMutex
-	cache map[string]*sql.Stmt
-	db    PrepareDB
+	mu      sync.Mutex
+	cache   map[stmtKey]*stmtEntry
+	front   *stmtEntry // most recently used, with the least recently used at back
+	back    *stmtEntry
+	maxSize int
+	db      PrepareDB
+	stats   StmtCacheStats
+}
+
+// StmtCacheStats are counters for a [StmtCache], returned by [StmtCache.Stats].
+type StmtCacheStats struct {
+	Hits          int64 // statements found in the cache
+	Misses        int64 // statements not found in the cache
+	PrepareErrors int64 // statements that failed to prepare
+	Reprepares    int64 // statements prepared again after a schema change
+	Size          int   // statements currently cached
+}
+
+// stmtKey identifies a statement by its query and, when wrapping a [Router], which database it was prepared on.
+type stmtKey struct {
+	target int // see [route], always 0 otherwise
+	query  string
+}
+
+type stmtEntry struct {
+	key     stmtKey
+	prev    *stmtEntry // towards the front of the LRU list
+	next    *stmtEntry
+	ready   chan struct{} // closed once stmt or err is set
+	stmt    *sql.Stmt
+	err     error
+	refs    int  // callers currently using stmt
+	evicted bool // removed from the cache, close once refs reaches 0
+}
+
+func (e *stmtEntry) isReady() bool {
+	select {
+	case <-e.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// StmtCacheOption configures a [StmtCache].
+type StmtCacheOption func(*StmtCache)
+
+// StmtCacheSize limits a [StmtCache] to n statements, evicting and closing the least recently used
+// statement when full. Statements are closed once any in-flight calls using them have finished.
+// The default of 0 means no limit.
+func StmtCacheSize(n int) StmtCacheOption {
+	return func(sc *StmtCache) {
+		sc.maxSize = n
+	}
 }
 
 // NewStmtCache creates a new statement cache wrapping the provided database connection.
-func NewStmtCache(db PrepareDB) *StmtCache {
-	return &StmtCache{
-		cache: make(map[string]*sql.Stmt),
+func NewStmtCache(db PrepareDB, opts ...StmtCacheOption) *StmtCache {
+	sc := &StmtCache{
+		cache: make(map[stmtKey]*stmtEntry),
 		db:    db,
 	}
+	for _, opt := range opts {
+		opt(sc)
+	}
+	return sc
 }
 
 func (sc *StmtCache) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
-	stmt, err := sc.getStmt(ctx, query) //nolint:sqlclosecheck // stmt is owned by cache, closed in Close
-	if err != nil {
-		return nil, err
-	}
-	return stmt.QueryContext(ctx, args...)
+	return withStmt(ctx, sc, query, func(stmt *sql.Stmt) (*sql.Rows, error) {
+		// rows keep the statement open until they're closed, even if it's evicted in the meantime
+		return stmt.QueryContext(ctx, args...)
+	})
 }
 
 func (sc *StmtCache) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
-	stmt, err := sc.getStmt(ctx, query) //nolint:sqlclosecheck // stmt is owned by cache, closed in Close
-	if err != nil {
-		return nil, err
+	return withStmt(ctx, sc, query, func(stmt *sql.Stmt) (sql.Result, error) {
+		return stmt.ExecContext(ctx, args...)
+	})
+}
+
+// withStmt calls f with the cached statement for query, on the database a [Router] picks for ctx if the cache wraps one.
+func withStmt[T any](ctx context.Context, sc *StmtCache, query string, f func(*sql.Stmt) (T, error)) (T, error) {
+	r, ok := sc.db.(*Router)
+	if !ok {
+		return withTargetStmt(ctx, sc, 0, sc.db, query, f)
 	}
-	return stmt.ExecContext(ctx, args...)
+	return route(ctx, r, r.isRead(ctx, query), func(target int, db DB) (T, error) {
+		pdb, ok := db.(PrepareDB)
+		if !ok {
+			var zero T
+			return zero, fmt.Errorf("%T does not support PrepareContext", db)
+		}
+		return withTargetStmt(ctx, sc, target, pdb, query, f)
+	})
 }
 
-func (sc *StmtCache) Close() error {
+// withTargetStmt calls f with the cached statement for query on db. If the statement was invalidated by a schema change,
+// it is prepared and f is called again, once.
+func withTargetStmt[T any](ctx context.Context, sc *StmtCache, target int, db PrepareDB, query string, f func(*sql.Stmt) (T, error)) (T, error) {
+	for retry := true; ; retry = false {
+		e, err := sc.acquire(ctx, target, db, query)
+		if err != nil {
+			var zero T
+			return zero, err
+		}
+		res, err := f(e.stmt)
+		if err != nil && retry && IsSchemaChanged(err) {
+			sc.mu.Lock()
+			_ = sc.evict(e)
+			sc.stats.Reprepares++
+			sc.mu.Unlock()
+			sc.release(e)
+			continue
+		}
+		sc.release(e)
+		return res, err
+	}
+}
+
+// Stats returns the cache's counters and current size.
+func (sc *StmtCache) Stats() StmtCacheStats {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	stats := sc.stats
+	stats.Size = len(sc.cache)
+	return stats
+}
+
+// Invalidate removes the statement for query from the cache, to be prepared again on next use.
+// The statement is closed once any in-flight calls using it have finished.
+func (sc *StmtCache) Invalidate(query string) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	var errs []error
+	for key, e := range sc.cache {
+		if key.query == query {
+			errs = append(errs, sc.evict(e))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// InvalidateAll removes all statements from the cache, for example after running migrations.
+// The cache can still be used afterwards.
+func (sc *StmtCache) InvalidateAll() error {
 	sc.mu.Lock()
 	defer sc.mu.Unlock()
 
 	var errs []error
-	for _, stmt := range sc.cache {
-		if err := stmt.Close(); err != nil {
+	for sc.front != nil {
+		if err := sc.evict(sc.front); err != nil {
 			errs = append(errs, err)
-			continue
 		}
 	}
 	if err := errors.Join(errs...); err != nil {
@@ -663,28 +1148,243 @@ func (sc *StmtCache) Close() error {
 	return nil
 }
 
-func (sc *StmtCache) getStmt(ctx context.Context, query string) (*sql.Stmt, error) {
-	sc.mu.RLock()
-	stmt, ok := sc.cache[query]
-	sc.mu.RUnlock()
-	if ok {
-		return stmt, nil
+func (sc *StmtCache) Close() error {
+	return sc.InvalidateAll()
+}
+
+// Tx returns a view of the cache bound to tx, so the same code can use cached statements inside and outside transactions.
+// Cached statements are used in tx with [sql.Tx.StmtContext]. Statements not yet cached are prepared on tx directly,
+// since preparing them on the wrapped connection may need a second connection while tx holds one.
+// The view must not be used after tx is committed or rolled back.
+func (sc *StmtCache) Tx(tx StmtDB) *TxStmtCache {
+	return &TxStmtCache{
+		sc:    sc,
+		tx:    tx,
+		stmts: make(map[string]*sql.Stmt),
+	}
+}
+
+// acquire returns the cached entry for query, preparing it if needed. Callers must [StmtCache.release] it once done with the statement.
+// Only callers of the same uncached query wait for it to be prepared. If the caller preparing it gives up because its
+// context is done, a waiter prepares it instead.
+func (sc *StmtCache) acquire(ctx context.Context, target int, db PrepareDB, query string) (*stmtEntry, error) {
+	lg := loggerFrom(ctx)
+
+	// comments may include per-request values like trace IDs, which would defeat caching
+	key := stmtKey{target: target, query: trimSQLComment(ctx, query)}
+
+	sc.mu.Lock()
+	if e, ok := sc.cache[key]; ok {
+		e, err := sc.hit(ctx, e)
+		if errors.Is(err, errPrepareAbandoned) {
+			return sc.acquire(ctx, target, db, query)
+		}
+		return e, err
+	}
+	sc.stats.Misses++
+
+	e := &stmtEntry{key: key, ready: make(chan struct{}), refs: 1}
+	sc.pushFront(e)
+	sc.cache[key] = e
+	for sc.maxSize > 0 && len(sc.cache) > sc.maxSize {
+		_ = sc.evict(sc.back)
+	}
+	sc.mu.Unlock()
+
+	pctx, plg := logStart(ctx, nil, "prepare", key.query, nil, Query{})
+	stmt, err := db.PrepareContext(pctx, key.query) //nolint:sqlclosecheck // stmt is stored in cache, closed in Close or on eviction
+	plg.finish(pctx, err, -1)
+
+	sc.mu.Lock()
+	e.stmt, e.err = stmt, err
+	if err != nil {
+		// don't cache the error, the next caller can try again
+		_ = sc.evict(e)
+		sc.stats.PrepareErrors++
+	}
+	sc.mu.Unlock()
+	close(e.ready)
+
+	if err != nil {
+		sc.release(e)
+		return nil, err
 	}
 
+	lg.cached(false)
+	return e, nil
+}
+
+// lookup is like [StmtCache.acquire], but returns a nil entry instead of preparing query if it isn't cached, or is
+// still being prepared, since that may need the connection the calling transaction holds.
+// Transactions are always on the primary of a [Router], which is target 0.
+func (sc *StmtCache) lookup(ctx context.Context, query string) (*stmtEntry, error) {
+	key := stmtKey{query: trimSQLComment(ctx, query)}
+
+	sc.mu.Lock()
+	if e, ok := sc.cache[key]; ok && e.isReady() {
+		return sc.hit(ctx, e)
+	}
+	sc.stats.Misses++
+	sc.mu.Unlock()
+	return nil, nil
+}
+
+// errPrepareAbandoned is returned by [StmtCache.hit] when the caller preparing the statement gave up because its
+// context was done, rather than the statement failing to prepare, so a caller with a live context can prepare it.
+var errPrepareAbandoned = errors.New("prepare abandoned")
+
+// hit acquires a cached entry, waiting for it to be prepared. It must be called with sc.mu held, and unlocks it.
+func (sc *StmtCache) hit(ctx context.Context, e *stmtEntry) (*stmtEntry, error) {
+	sc.unlink(e)
+	sc.pushFront(e)
+	e.refs++
+	sc.stats.Hits++
+	sc.mu.Unlock()
+
+	select {
+	case <-e.ready:
+	case <-ctx.Done():
+		sc.release(e)
+		return nil, ctx.Err()
+	}
+	if e.err != nil {
+		sc.release(e)
+		if ctx.Err() == nil && (errors.Is(e.err, context.Canceled) || errors.Is(e.err, context.DeadlineExceeded)) {
+			return nil, errPrepareAbandoned
+		}
+		return nil, e.err
+	}
+	loggerFrom(ctx).cached(true)
+	return e, nil
+}
+
+func (sc *StmtCache) release(e *stmtEntry) {
 	sc.mu.Lock()
 	defer sc.mu.Unlock()
 
-	// check again in case another goroutine prepared it
-	stmt, ok = sc.cache[query]
-	if ok {
+	e.refs--
+	if e.evicted && e.refs == 0 && e.stmt != nil {
+		_ = e.stmt.Close()
+	}
+}
+
+// evict removes an entry from the cache, closing its statement now if it is unused, or otherwise on its last release.
+// It must be called with sc.mu held.
+func (sc *StmtCache) evict(e *stmtEntry) error {
+	if e.evicted {
+		return nil
+	}
+	sc.unlink(e)
+	delete(sc.cache, e.key)
+	e.evicted = true
+	if e.refs == 0 && e.stmt != nil {
+		return e.stmt.Close()
+	}
+	return nil
+}
+
+// pushFront adds e to the front of the LRU list as the most recently used. It must be called with sc.mu held.
+func (sc *StmtCache) pushFront(e *stmtEntry) {
+	e.prev, e.next = nil, sc.front
+	if sc.front != nil {
+		sc.front.prev = e
+	} else {
+		sc.back = e
+	}
+	sc.front = e
+}
+
+// unlink removes e from the LRU list. It must be called with sc.mu held.
+func (sc *StmtCache) unlink(e *stmtEntry) {
+	if e.prev != nil {
+		e.prev.next = e.next
+	} else {
+		sc.front = e.next
+	}
+	if e.next != nil {
+		e.next.prev = e.prev
+	} else {
+		sc.back = e.prev
+	}
+	e.prev, e.next = nil, nil
+}
+
+// TxStmtCache is a view of a [StmtCache] bound to a transaction, created with [StmtCache.Tx].
+type TxStmtCache struct {
+	sc    *StmtCache
+	tx    StmtDB
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt // transaction statements, closed by the transaction when it ends
+}
+
+func (tc *TxStmtCache) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	stmt, err := tc.stmt(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		tc.forget(ctx, query, stmt)
+		return nil, err
+	}
+	return rows, nil
+}
+
+func (tc *TxStmtCache) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	stmt, err := tc.stmt(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	res, err := stmt.ExecContext(ctx, args...)
+	if err != nil {
+		tc.forget(ctx, query, stmt)
+		return nil, err
+	}
+	return res, nil
+}
+
+func (tc *TxStmtCache) stmt(ctx context.Context, query string) (*sql.Stmt, error) {
+	query = trimSQLComment(ctx, query)
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if stmt, ok := tc.stmts[query]; ok {
+		loggerFrom(ctx).cached(true)
 		return stmt, nil
 	}
 
-	stmt, err := sc.db.PrepareContext(ctx, query) //nolint:sqlclosecheck // stmt is stored in cache, closed in Close
+	e, err := tc.sc.lookup(ctx, query)
 	if err != nil {
 		return nil, err
 	}
 
-	sc.cache[query] = stmt
+	var stmt *sql.Stmt
+	if e != nil {
+		// the transaction statement keeps the cached one open until the transaction ends, so it's safe to release now
+		stmt = tc.tx.StmtContext(ctx, e.stmt) //nolint:sqlclosecheck // closed by the transaction
+		tc.sc.release(e)
+	} else {
+		pctx, plg := logStart(ctx, nil, "prepare", query, nil, Query{})
+		stmt, err = tc.tx.PrepareContext(pctx, query) //nolint:sqlclosecheck // closed by the transaction
+		plg.finish(pctx, err, -1)
+		if err != nil {
+			return nil, err
+		}
+		loggerFrom(ctx).cached(false)
+	}
+	tc.stmts[query] = stmt
 	return stmt, nil
 }
+
+// forget removes a statement after an error, which may have come from preparing it in the transaction.
+func (tc *TxStmtCache) forget(ctx context.Context, query string, stmt *sql.Stmt) {
+	query = trimSQLComment(ctx, query)
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if tc.stmts[query] == stmt {
+		delete(tc.stmts, query)
+	}
+}
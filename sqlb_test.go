@@ -3,9 +3,12 @@ package sqlb_test
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
 	"slices"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -606,6 +609,113 @@ func TestLogRows(t *testing.T) {
 	}
 }
 
+func ExampleWithLogEventFunc() {
+	ctx := context.Background()
+	db := newDB(ctx)
+	defer db.Close()
+
+	ctx = sqlb.WithLogEventFunc(ctx, func(ctx context.Context, ev sqlb.LogEvent) {
+		fmt.Printf("type=%s query=%s args=%v rows=%d err=%v\n", ev.Type, ev.Query, ev.Args, ev.Rows, ev.Err)
+	})
+
+	_ = sqlb.Exec(ctx, db, "INSERT INTO tasks ?", sqlb.InsertSQL(Task{Name: "alice"}, Task{Name: "bob"}))
+
+	var names []string
+	_ = sqlb.QueryRows(ctx, db, sqlb.AppendValue(&names), "SELECT name FROM tasks WHERE name != ?", "carol")
+	// Output:
+	// type=exec query=INSERT INTO tasks (name, age) VALUES (?, ?), (?, ?) args=[alice 0 bob 0] rows=2 err=<nil>
+	// type=query query=SELECT name FROM tasks WHERE name != ? args=[carol] rows=2 err=<nil>
+}
+
+func TestLogEvent(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+
+	cache := sqlb.NewStmtCache(db)
+	defer cache.Close()
+
+	var events []sqlb.LogEvent
+	ctx = sqlb.WithLogEventFunc(ctx, func(ctx context.Context, ev sqlb.LogEvent) {
+		events = append(events, ev)
+	})
+
+	var x int
+	_ = sqlb.QueryRow(ctx, db, sqlb.Scan(&x), "SELECT * FROM nonexistent")
+	_ = sqlb.QueryRow(ctx, cache, sqlb.Scan(&x), "SELECT ?", 1)
+	_ = sqlb.QueryRow(ctx, cache, sqlb.Scan(&x), "SELECT ?", 1)
+	for range sqlb.Each(ctx, db, sqlb.Scan(&x), "SELECT 1 UNION ALL SELECT 2") {
+	}
+
+	if len(events) != 4 {
+		t.Fatalf("got %d events, want 4", len(events))
+	}
+	for _, ev := range events {
+		if ev.Caller != "sqlb_test.TestLogEvent" {
+			t.Errorf("got caller %q, want %q", ev.Caller, "sqlb_test.TestLogEvent")
+		}
+	}
+	if ev := events[0]; ev.Err == nil || ev.Rows != 0 || ev.Cached {
+		t.Errorf("unexpected event[0]: %+v", ev)
+	}
+	if ev := events[1]; ev.Err != nil || ev.Rows != 1 || !ev.Cached || ev.Hit || len(ev.Args) != 1 {
+		t.Errorf("unexpected event[1]: %+v", ev)
+	}
+	if ev := events[2]; ev.Err != nil || ev.Rows != 1 || !ev.Cached || !ev.Hit {
+		t.Errorf("unexpected event[2]: %+v", ev)
+	}
+	if ev := events[3]; ev.Err != nil || ev.Rows != 2 || ev.Cached {
+		t.Errorf("unexpected event[3]: %+v", ev)
+	}
+
+	// statement preparation is only logged when asked for
+	events = nil
+	_ = sqlb.QueryRow(sqlb.WithLogPrepares(ctx), cache, sqlb.Scan(&x), "SELECT ? + 1", 1)
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+	if ev := events[0]; ev.Type != "prepare" || ev.Err != nil || ev.Query != "SELECT ? + 1" || ev.Caller != "sqlb_test.TestLogEvent" {
+		t.Errorf("unexpected prepare event: %+v", ev)
+	}
+}
+
+func TestLogStart(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+
+	inflight := map[uint64]sqlb.LogEvent{}
+	var finished []sqlb.LogEvent
+	ctx = sqlb.WithLogEventFunc(ctx, func(ctx context.Context, ev sqlb.LogEvent) {
+		if _, ok := inflight[ev.ID]; !ok {
+			t.Errorf("finish for %d without start", ev.ID)
+		}
+		delete(inflight, ev.ID)
+		finished = append(finished, ev)
+	})
+
+	ctx = sqlb.WithLogStartFunc(ctx, func(ctx context.Context, ev sqlb.LogEvent) {
+		if ev.Query != "SELECT * FROM nonexistent" || ev.Err != nil || ev.Dur != 0 || ev.Start.IsZero() {
+			t.Errorf("unexpected start event: %+v", ev)
+		}
+		inflight[ev.ID] = ev
+	})
+
+	var x int
+	if err := sqlb.QueryRow(ctx, db, sqlb.Scan(&x), "SELECT * FROM nonexistent"); err == nil {
+		t.Fatal("expected error for invalid table")
+	}
+
+	if len(inflight) != 0 {
+		t.Errorf("got %d queries still in flight", len(inflight))
+	}
+	if len(finished) != 1 || finished[0].Err == nil {
+		t.Errorf("expected one finished event with error, got %+v", finished)
+	}
+}
+
 func ExampleStmtCache() {
 	ctx := context.Background()
 	db := newDB(ctx)
@@ -677,6 +787,291 @@ func TestStmtCache(t *testing.T) {
 	}
 }
 
+func ExampleStmtCache_Tx() {
+	ctx := context.Background()
+	db := newDB(ctx)
+	defer db.Close()
+
+	cache := sqlb.NewStmtCache(db)
+	defer cache.Close()
+
+	insertTask := func(ctx context.Context, db sqlb.ExecDB, name string) error {
+		return sqlb.Exec(ctx, db, "INSERT INTO tasks (name) VALUES (?)", name)
+	}
+
+	_ = insertTask(ctx, cache, "a")
+
+	tx, _ := db.BeginTx(ctx, nil)
+	_ = insertTask(ctx, cache.Tx(tx), "b") // uses cached statement in tx
+	_ = tx.Commit()
+
+	var count int
+	_ = sqlb.QueryRow(ctx, cache, sqlb.Scan(&count), "SELECT count(*) FROM tasks")
+	fmt.Println(count)
+	// Output:
+	// 2
+}
+
+func TestStmtCacheTx(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+
+	// the transaction holds the only connection, so nothing can be prepared outside it
+	db.SetMaxOpenConns(1)
+
+	var prepared []string
+	cache := sqlb.NewStmtCache(prepareWrap{db, func(ctx context.Context, query string) {
+		prepared = append(prepared, query)
+	}})
+	defer cache.Close()
+
+	if err := sqlb.Exec(ctx, cache, "INSERT INTO tasks (name) VALUES (?)", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []sqlb.LogEvent
+	ctx = sqlb.WithLogEventFunc(ctx, func(ctx context.Context, ev sqlb.LogEvent) {
+		events = append(events, ev)
+	})
+	ctx = sqlb.WithLogPrepares(ctx)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	tc := cache.Tx(tx)
+	for _, name := range []string{"b", "c"} {
+		if err := sqlb.Exec(ctx, tc, "INSERT INTO tasks (name) VALUES (?)", name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var count int
+	for range 2 {
+		if err := sqlb.QueryRow(ctx, tc, sqlb.Scan(&count), "SELECT count(*) FROM tasks"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count != 3 {
+		t.Errorf("got %d tasks in tx, want 3", count)
+	}
+	if err := sqlb.Exec(ctx, tc, "INSERT INTO tasks (nope) VALUES (?)", 1); err == nil {
+		t.Error("expected error for invalid column")
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(prepared) != 1 {
+		t.Errorf("expected only statement prepared outside tx, got %q", prepared)
+	}
+
+	var got []string
+	for _, ev := range events {
+		got = append(got, fmt.Sprintf("%s %t %t", ev.Type, ev.Cached, ev.Hit))
+	}
+	want := []string{
+		"exec true true", "exec true true",
+		"prepare false false", "query true false", "query true true",
+		"prepare false false", "exec false false",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got events %q, want %q", got, want)
+	}
+}
+
+func TestStmtCacheTxPreparing(t *testing.T) {
+	t.Parallel()
+	db := newDB(t.Context())
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	cache := sqlb.NewStmtCache(db)
+	defer cache.Close()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// preparing on the pool waits for the connection the transaction holds
+	done := make(chan error, 1)
+	go func() { done <- sqlb.Exec(ctx, cache, "INSERT INTO tasks (name) VALUES (?)", "a") }()
+	for cache.Stats().Size == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// so the transaction prepares its own rather than waiting for it
+	if err := sqlb.Exec(ctx, cache.Tx(tx), "INSERT INTO tasks (name) VALUES (?)", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	var count int
+	if err := sqlb.QueryRow(ctx, cache, sqlb.Scan(&count), "SELECT count(*) FROM tasks"); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("got %d tasks, want 2", count)
+	}
+}
+
+func TestStmtCacheStats(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+
+	var prepareCalls int
+	cache := sqlb.NewStmtCache(prepareWrap{db, func(ctx context.Context, query string) {
+		prepareCalls++
+	}})
+	defer cache.Close()
+
+	for _, q := range []string{"SELECT 1", "SELECT 1", "SELECT 2", "INVALID SQL"} {
+		_ = sqlb.Exec(ctx, cache, q)
+	}
+	_ = sqlb.Exec(sqlb.WithSQLComment(ctx, "trace", "a"), cache, "SELECT 1")
+	want := sqlb.StmtCacheStats{Hits: 2, Misses: 3, PrepareErrors: 1, Size: 2}
+	if got := cache.Stats(); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if err := cache.Invalidate("SELECT 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Invalidate("SELECT 3"); err != nil {
+		t.Fatal(err)
+	}
+	if size := cache.Stats().Size; size != 1 {
+		t.Errorf("got size %d after invalidate, want 1", size)
+	}
+
+	if err := cache.InvalidateAll(); err != nil {
+		t.Fatal(err)
+	}
+	if size := cache.Stats().Size; size != 0 {
+		t.Errorf("got size %d after invalidate all, want 0", size)
+	}
+
+	if err := sqlb.Exec(ctx, cache, "SELECT 2"); err != nil {
+		t.Fatal(err)
+	}
+	if prepareCalls != 4 {
+		t.Errorf("got %d prepareCalls, want 4", prepareCalls)
+	}
+}
+
+func TestStmtCacheSchemaChanged(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+
+	// SQLite re-prepares statements itself after most schema changes, so simulate one it can't recover from
+	sdb, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	connector := &schemaChangeConnector{drv: sdb.Driver()}
+	_ = sdb.Close()
+
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if err := sqlb.Exec(ctx, db, "CREATE TABLE schema_test (id integer)"); err != nil {
+		t.Fatal(err)
+	}
+
+	const query = "DELETE FROM schema_test"
+	var prepareCalls int
+	cache := sqlb.NewStmtCache(prepareWrap{db, func(ctx context.Context, q string) {
+		if q == query {
+			prepareCalls++
+		}
+	}})
+	defer cache.Close()
+
+	if err := sqlb.Exec(ctx, cache, query); err != nil {
+		t.Fatal(err)
+	}
+	connector.changes.Add(1)
+	if err := sqlb.Exec(ctx, cache, query); err != nil {
+		t.Fatal(err)
+	}
+	if prepareCalls != 2 {
+		t.Errorf("got %d prepareCalls, want 2", prepareCalls)
+	}
+	if got := cache.Stats().Reprepares; got != 1 {
+		t.Errorf("got %d reprepares, want 1", got)
+	}
+}
+
+// schemaChangeConnector opens connections whose statements fail with SQLite's schema changed error
+// once changes has been incremented since they were prepared.
+type schemaChangeConnector struct {
+	drv     driver.Driver
+	changes atomic.Int64
+}
+
+func (c *schemaChangeConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.drv.Open(":memory:")
+	if err != nil {
+		return nil, err
+	}
+	return schemaChangeConn{conn, c}, nil
+}
+
+func (c *schemaChangeConnector) Driver() driver.Driver {
+	return c.drv
+}
+
+type schemaChangeConn struct {
+	driver.Conn
+	c *schemaChangeConnector
+}
+
+func (c schemaChangeConn) Prepare(query string) (driver.Stmt, error) {
+	stmt, err := c.Conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return schemaChangeStmt{stmt, c.c, c.c.changes.Load()}, nil
+}
+
+type schemaChangeStmt struct {
+	driver.Stmt
+	c       *schemaChangeConnector
+	changes int64
+}
+
+var errSchemaChanged = errors.New("sqlite3: database schema has changed")
+
+func (s schemaChangeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if s.c.changes.Load() != s.changes {
+		return nil, errSchemaChanged
+	}
+	return s.Stmt.Exec(args) //nolint:staticcheck // the wrapped driver's context methods are hidden
+}
+
+func (s schemaChangeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if s.c.changes.Load() != s.changes {
+		return nil, errSchemaChanged
+	}
+	return s.Stmt.Query(args) //nolint:staticcheck // the wrapped driver's context methods are hidden
+}
+
 func TestStmtCachePrepareError(t *testing.T) {
 	t.Parallel()
 	ctx := t.Context()
@@ -696,6 +1091,161 @@ func TestStmtCachePrepareError(t *testing.T) {
 	}
 }
 
+func TestStmtCacheSize(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+
+	var prepared []string
+	cache := sqlb.NewStmtCache(prepareWrap{db, func(ctx context.Context, query string) {
+		prepared = append(prepared, query)
+	}}, sqlb.StmtCacheSize(2))
+	defer cache.Close()
+
+	for _, q := range []string{"select 1", "select 2", "select 1", "select 3", "select 2", "select 1"} {
+		if err := sqlb.Exec(ctx, cache, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// select 2 is evicted by select 3, and then select 1 by select 2
+	want := []string{"select 1", "select 2", "select 3", "select 2", "select 1"}
+	if fmt.Sprint(prepared) != fmt.Sprint(want) {
+		t.Errorf("got prepared %q, want %q", prepared, want)
+	}
+}
+
+func TestStmtCacheSizeConcurrent(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+
+	cache := sqlb.NewStmtCache(db, sqlb.StmtCacheSize(2))
+	defer cache.Close()
+
+	// every goroutine's statement is evicted by the others while they read rows
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Go(func() {
+			for j := range 50 {
+				var got []int
+				query := fmt.Sprintf("select %d union all select %d", i, j)
+				if err := sqlb.QueryRows(ctx, cache, sqlb.AppendValue(&got), query); err != nil {
+					t.Error(err)
+					return
+				}
+				if len(got) != 2 || got[0] != i || got[1] != j {
+					t.Errorf("unexpected rows %v", got)
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+func TestStmtCacheConcurrentPrepare(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+
+	preparing, unblock := make(chan struct{}), make(chan struct{})
+	var slowPrepares atomic.Int32
+	cache := sqlb.NewStmtCache(prepareWrap{db, func(ctx context.Context, query string) {
+		if query == "select 'slow'" {
+			if slowPrepares.Add(1) == 1 {
+				close(preparing)
+			}
+			<-unblock
+		}
+	}})
+	defer cache.Close()
+
+	if err := sqlb.Exec(ctx, cache, "select 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Go(func() {
+			var s string
+			if err := sqlb.QueryRow(ctx, cache, sqlb.Scan(&s), "select 'slow'"); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	<-preparing
+
+	// cached and other uncached queries aren't blocked by the slow prepare
+	for _, q := range []string{"select 1", "select 2"} {
+		if err := sqlb.Exec(ctx, cache, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a waiter gives up when its context is done
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := sqlb.Exec(cctx, cache, "select 'slow'"); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+
+	close(unblock)
+	wg.Wait()
+
+	if n := slowPrepares.Load(); n != 1 {
+		t.Errorf("got %d prepares of slow query, want 1", n)
+	}
+}
+
+func TestStmtCacheConcurrentPrepareCancel(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+
+	preparing := make(chan struct{})
+	var prepares atomic.Int32
+	cache := sqlb.NewStmtCache(prepareWrap{db, func(ctx context.Context, query string) {
+		// the first prepare blocks until its caller gives up
+		if prepares.Add(1) == 1 {
+			close(preparing)
+			<-ctx.Done()
+		}
+	}})
+	defer cache.Close()
+
+	cctx, cancel := context.WithCancel(ctx)
+	first := make(chan error)
+	go func() {
+		first <- sqlb.Exec(cctx, cache, "select 1")
+	}()
+	<-preparing
+
+	second := make(chan error)
+	go func() {
+		second <- sqlb.Exec(ctx, cache, "select 1")
+	}()
+	for cache.Stats().Hits == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+	// the waiter's context is live, so it prepares the statement itself
+	if err := <-second; err != nil {
+		t.Errorf("got %v, want nil", err)
+	}
+	if n := prepares.Load(); n != 2 {
+		t.Errorf("got %d prepares, want 2", n)
+	}
+}
+
 func BenchmarkStmtCache(b *testing.B) {
 	ctx := b.Context()
 
@@ -728,6 +1278,26 @@ func BenchmarkStmtCache(b *testing.B) {
 	}
 }
 
+func BenchmarkStmtCacheParallel(b *testing.B) {
+	ctx := b.Context()
+	db := newDB(ctx)
+	defer db.Close()
+
+	cache := sqlb.NewStmtCache(db)
+	defer cache.Close()
+
+	queries := []string{"select 1", "select 2", "select 3", "select 4"}
+	b.RunParallel(func(pb *testing.PB) {
+		var i int
+		for pb.Next() {
+			if _, err := cache.ExecContext(ctx, queries[i%len(queries)]); err != nil {
+				b.Fatal(err)
+			}
+			i++
+		}
+	})
+}
+
 func Example() {
 	ctx := context.Background()
 	db := newDB(ctx)
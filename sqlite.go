@@ -0,0 +1,174 @@
+package sqlb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// SQLiteOptions configures the pools opened by [OpenSQLite].
+type SQLiteOptions struct {
+	// BusyTimeout is how long a connection waits for a lock before failing with a busy error. The default is 5s.
+	BusyTimeout time.Duration
+	// Readers is the maximum number of reader connections. The default is the number of CPUs, and at least 4.
+	Readers int
+	// Pragmas are extra statements run on each new connection, such as "PRAGMA cache_size = -20000".
+	Pragmas []string
+}
+
+// SQLiteDB is a handle for a SQLite database opened with [OpenSQLite], with a pool for writes and one for reads.
+type SQLiteDB struct {
+	Writer  *sql.DB // a single connection, so writes don't contend for the database lock
+	Readers *sql.DB // read-only connections, which WAL mode lets read while writing
+	router  *Router
+}
+
+// OpenSQLite opens the SQLite database at dsn with driverName, which must already be registered, as a pool of one
+// writer connection and a pool of reader connections. Every connection has the busy_timeout and foreign_keys pragmas
+// set, the database uses WAL mode, and readers are query_only. dsn must be a file, since each connection to an
+// in-memory database would be a separate database.
+//
+// Queries that only read go to the readers, and everything else to the writer, as with a [Router].
+// Transactions, including those from [InTx] and [BeginTx], always use the writer.
+func OpenSQLite(driverName, dsn string, opts *SQLiteOptions) (*SQLiteDB, error) {
+	if opts == nil {
+		opts = &SQLiteOptions{}
+	}
+	busyTimeout := opts.BusyTimeout
+	if busyTimeout == 0 {
+		busyTimeout = 5 * time.Second
+	}
+	readers := opts.Readers
+	if readers == 0 {
+		readers = max(4, runtime.NumCPU())
+	}
+
+	connector, err := openConnector(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	common := []string{
+		fmt.Sprintf("PRAGMA busy_timeout = %d", busyTimeout.Milliseconds()),
+		"PRAGMA foreign_keys = ON",
+	}
+
+	// WAL mode is persistent in the database file, so is set by the writer before any readers connect
+	writer := sql.OpenDB(pragmaConnector{connector, append(append([]string{
+		"PRAGMA journal_mode = WAL",
+		"PRAGMA synchronous = NORMAL",
+	}, common...), opts.Pragmas...)})
+	writer.SetMaxOpenConns(1)
+	if err := writer.PingContext(context.Background()); err != nil {
+		_ = writer.Close()
+		return nil, fmt.Errorf("open writer: %w", err)
+	}
+
+	reader := sql.OpenDB(pragmaConnector{connector, append(append([]string{
+		"PRAGMA query_only = ON",
+	}, common...), opts.Pragmas...)})
+	reader.SetMaxOpenConns(readers)
+	reader.SetMaxIdleConns(readers)
+
+	return &SQLiteDB{
+		Writer:  writer,
+		Readers: reader,
+		router:  NewRouter(writer, []DB{reader}),
+	}, nil
+}
+
+func (s *SQLiteDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return s.router.QueryContext(ctx, query, args...)
+}
+
+func (s *SQLiteDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return s.router.ExecContext(ctx, query, args...)
+}
+
+func (s *SQLiteDB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return s.router.PrepareContext(ctx, query)
+}
+
+// BeginTx starts a transaction on the writer.
+func (s *SQLiteDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return s.Writer.BeginTx(ctx, opts)
+}
+
+// Close closes both pools.
+func (s *SQLiteDB) Close() error {
+	return errors.Join(s.Readers.Close(), s.Writer.Close())
+}
+
+// openConnector returns a connector for dsn with the registered driver, like [sql.Open] does internally.
+func openConnector(driverName, dsn string) (driver.Connector, error) {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	drv := db.Driver()
+	_ = db.Close()
+
+	if dc, ok := drv.(driver.DriverContext); ok {
+		return dc.OpenConnector(dsn)
+	}
+	return dsnConnector{drv, dsn}, nil
+}
+
+type dsnConnector struct {
+	drv driver.Driver
+	dsn string
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.drv.Open(c.dsn)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.drv
+}
+
+// pragmaConnector runs pragmas on each new connection, since most are per connection rather than per database.
+type pragmaConnector struct {
+	driver.Connector
+	pragmas []string
+}
+
+func (c pragmaConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range c.pragmas {
+		if err := execConn(ctx, conn, p); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+	}
+	return conn, nil
+}
+
+func execConn(ctx context.Context, conn driver.Conn, query string) error {
+	if ec, ok := conn.(driver.ExecerContext); ok {
+		_, err := ec.ExecContext(ctx, query, nil)
+		if !errors.Is(err, driver.ErrSkip) {
+			return err
+		}
+	}
+
+	stmt, err := conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	if sc, ok := stmt.(driver.StmtExecContext); ok {
+		_, err = sc.ExecContext(ctx, nil)
+		return err
+	}
+	_, err = stmt.Exec(nil) //nolint:staticcheck // fallback for drivers without StmtExecContext
+	return err
+}
@@ -0,0 +1,138 @@
+package sqlb_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.senan.xyz/sqlb"
+)
+
+func newSQLiteDB(t *testing.T) *sqlb.SQLiteDB {
+	t.Helper()
+
+	db, err := sqlb.OpenSQLite("sqlite3", "file:"+filepath.Join(t.TempDir(), "db.sqlite"), &sqlb.SQLiteOptions{
+		BusyTimeout: time.Second,
+		Readers:     2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	if err := sqlb.Exec(t.Context(), db, `create table tasks (id integer primary key autoincrement, name text not null default "", age integer not null default 0)`); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestOpenSQLite(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newSQLiteDB(t)
+
+	for _, c := range []struct {
+		db    *sql.DB
+		query string
+		want  string
+	}{
+		{db.Writer, "PRAGMA journal_mode", "wal"},
+		{db.Writer, "PRAGMA foreign_keys", "1"},
+		{db.Writer, "PRAGMA busy_timeout", "1000"},
+		{db.Writer, "PRAGMA query_only", "0"},
+		{db.Readers, "PRAGMA journal_mode", "wal"},
+		{db.Readers, "PRAGMA foreign_keys", "1"},
+		{db.Readers, "PRAGMA query_only", "1"},
+	} {
+		var got string
+		if err := sqlb.QueryRow(ctx, c.db, sqlb.Scan(&got), c.query); err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("%s: got %s, want %s", c.query, got, c.want)
+		}
+	}
+
+	// writes returning rows go to the writer
+	var task Task
+	if err := sqlb.QueryRow(ctx, db, &task, "INSERT INTO tasks (name) VALUES (?) RETURNING *", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sqlb.Exec(ctx, db.Readers, "INSERT INTO tasks (name) VALUES (?)", "b"); err == nil {
+		t.Error("expected error writing with reader")
+	}
+}
+
+func TestOpenSQLiteTx(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newSQLiteDB(t)
+
+	count := func() (n int) {
+		t.Helper()
+		if err := sqlb.QueryRow(ctx, db, sqlb.Scan(&n), "SELECT count(*) FROM tasks"); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	err := sqlb.InTx(ctx, db, nil, func(ctx context.Context, tx *sqlb.Tx) error {
+		if err := sqlb.Exec(ctx, tx, "INSERT INTO tasks (name) VALUES (?)", "a"); err != nil {
+			return err
+		}
+		// readers see the last commit while the writer is in a transaction
+		if n := count(); n != 0 {
+			return fmt.Errorf("got %d tasks before commit, want 0", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 1 {
+		t.Errorf("got %d tasks after commit, want 1", n)
+	}
+
+	txCtx, tx, err := sqlb.BeginTx(ctx, db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := sqlb.Exec(txCtx, sqlb.NewResolver(db), "INSERT INTO tasks (name) VALUES (?)", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 2 {
+		t.Errorf("got %d tasks after commit, want 2", n)
+	}
+}
+
+func TestOpenSQLiteConcurrent(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newSQLiteDB(t)
+
+	errs := make(chan error)
+	for i := range 8 {
+		go func() {
+			var err error
+			if i%2 == 0 {
+				err = sqlb.Exec(ctx, db, "INSERT INTO tasks (name) VALUES (?)", fmt.Sprint(i))
+			} else {
+				var n int
+				err = sqlb.QueryRow(ctx, db, sqlb.Scan(&n), "SELECT count(*) FROM tasks")
+			}
+			errs <- err
+		}()
+	}
+	for range 8 {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
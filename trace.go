@@ -0,0 +1,45 @@
+package sqlb
+
+import (
+	"context"
+	"strings"
+)
+
+// Tracer starts spans for database operations. It is intentionally minimal so that an adapter for a tracing
+// library such as OpenTelemetry can be written without sqlb depending on it.
+type Tracer interface {
+	// StartSpan starts a span named after the operation type: "query", "exec", or "prepare" for statements
+	// prepared by [StmtCache]. The returned context is used for the operation.
+	StartSpan(ctx context.Context, name string, attrs ...SpanAttr) (context.Context, Span)
+}
+
+// Span is a span started by a [Tracer].
+type Span interface {
+	// End ends the span with the operation's error, and rows read or affected, or -1 if unknown.
+	End(err error, rows int64)
+}
+
+// SpanAttr is a span attribute. Spans have the attributes "db.statement", the SQL sent to the database,
+// and "db.operation", its leading keyword such as "SELECT" or "INSERT".
+type SpanAttr struct {
+	Key   string
+	Value string
+}
+
+type tracerContextKey struct{}
+
+// WithTracer returns a context that will trace queries using t.
+func WithTracer(ctx context.Context, t Tracer) context.Context {
+	return context.WithValue(ctx, tracerContextKey{}, t)
+}
+
+func tracer(ctx context.Context) Tracer {
+	t, _ := ctx.Value(tracerContextKey{}).(Tracer)
+	return t
+}
+
+// operation returns the leading keyword of a fingerprinted query.
+func operation(fingerprint string) string {
+	op, _, _ := strings.Cut(strings.TrimLeft(fingerprint, "("), " ")
+	return strings.ToUpper(op)
+}
@@ -0,0 +1,92 @@
+package sqlb_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"go.senan.xyz/sqlb"
+)
+
+func ExampleWithTracer() {
+	ctx := context.Background()
+	db := newDB(ctx)
+	defer db.Close()
+
+	ctx = sqlb.WithTracer(ctx, &testTracer{})
+
+	var task Task
+	_ = sqlb.QueryRow(ctx, db, &task, "INSERT INTO tasks ? RETURNING *", sqlb.InsertSQL(Task{Name: "alice"}))
+	// Output:
+	// start query [{db.statement INSERT INTO tasks (name, age) VALUES (?, ?) RETURNING *} {db.operation INSERT}]
+	// end query err=<nil> rows=1
+}
+
+func TestTracer(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+
+	cache := sqlb.NewStmtCache(db)
+	defer cache.Close()
+
+	tr := &testTracer{quiet: true}
+	ctx = sqlb.WithTracer(ctx, tr)
+
+	_ = sqlb.Exec(ctx, cache, "INSERT INTO tasks (name) VALUES (?)", "alice")
+	_ = sqlb.Exec(ctx, cache, "INVALID")
+
+	if len(tr.spans) != 4 {
+		t.Fatalf("got %d spans, want 4", len(tr.spans))
+	}
+
+	exec, prepare := tr.spans[0], tr.spans[1]
+	if exec.name != "exec" || exec.parent != nil || exec.err != nil || exec.rows != 1 {
+		t.Errorf("unexpected exec span: %+v", exec)
+	}
+	if prepare.name != "prepare" || prepare.parent != exec || prepare.rows != -1 {
+		t.Errorf("unexpected prepare span: %+v", prepare)
+	}
+	if got := prepare.attrs[1]; got != (sqlb.SpanAttr{Key: "db.operation", Value: "INSERT"}) {
+		t.Errorf("unexpected operation attr: %v", got)
+	}
+
+	invalidExec, invalidPrepare := tr.spans[2], tr.spans[3]
+	if invalidExec.err == nil || invalidPrepare.err == nil || invalidPrepare.parent != invalidExec {
+		t.Errorf("expected failed spans, got %+v %+v", invalidExec, invalidPrepare)
+	}
+}
+
+type testTracer struct {
+	quiet bool
+	spans []*testSpan
+}
+
+type testSpan struct {
+	tr     *testTracer
+	name   string
+	attrs  []sqlb.SpanAttr
+	parent *testSpan
+	err    error
+	rows   int64
+}
+
+type testSpanContextKey struct{}
+
+func (tr *testTracer) StartSpan(ctx context.Context, name string, attrs ...sqlb.SpanAttr) (context.Context, sqlb.Span) {
+	parent, _ := ctx.Value(testSpanContextKey{}).(*testSpan)
+	span := &testSpan{tr: tr, name: name, attrs: attrs, parent: parent}
+	tr.spans = append(tr.spans, span)
+	if !tr.quiet {
+		fmt.Println("start", name, attrs)
+	}
+	return context.WithValue(ctx, testSpanContextKey{}, span), span
+}
+
+func (s *testSpan) End(err error, rows int64) {
+	s.err, s.rows = err, rows
+	if !s.tr.quiet {
+		fmt.Printf("end %s err=%v rows=%d\n", s.name, err, rows)
+	}
+}
@@ -0,0 +1,396 @@
+package sqlb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// TxDB is an interface compatible with [*sql.DB] or [*sql.Conn] for beginning transactions.
+type TxDB interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// ErrNoTx is returned by [OnCommit] and [OnRollback] when the context has no transaction from [BeginTx] or [InTx].
+var ErrNoTx = errors.New("no transaction in context")
+
+// TxOptions configures a transaction run with [InTx].
+type TxOptions struct {
+	Isolation sql.IsolationLevel
+	ReadOnly  bool
+
+	// MaxRetries is how many times the transaction is retried after a busy error or serialization failure.
+	// See [IsBusy] and [IsSerializationFailure]. The default of 0 means no retries.
+	MaxRetries int
+	// Backoff returns the delay before retry n, starting from 1. The default is exponential with jitter, from 10ms up to 1s.
+	Backoff func(n int) time.Duration
+}
+
+// InTx runs fn in a transaction from [BeginTx], committing if it returns nil and rolling back if it returns an error,
+// panics, or exits the goroutine. fn is given a context carrying the transaction, so [OnCommit], [DBFromContext],
+// and nested calls to InTx or BeginTx on the same database use it, with nested calls running in a savepoint.
+// opts may be nil for the driver's defaults, and is ignored for savepoints.
+//
+// If beginning, fn, or committing fails with a retryable error, the whole transaction is retried as configured by
+// [TxOptions.MaxRetries], so fn should have no other side effects. Savepoints aren't retried, since the error
+// usually aborts the outer transaction too.
+func InTx(ctx context.Context, db TxDB, opts *TxOptions, fn func(ctx context.Context, tx *Tx) error) error {
+	if opts == nil {
+		opts = &TxOptions{}
+	}
+	backoff := opts.Backoff
+	if backoff == nil {
+		backoff = defaultBackoff
+	}
+	parent, _ := ctx.Value(txContextKey{}).(*Tx)
+	nested := parent != nil && parent.begunOn(db)
+
+	for n := 0; ; n++ {
+		err := runTx(ctx, db, opts, fn)
+		if err == nil || nested || n >= opts.MaxRetries || !isRetryable(err) {
+			return err
+		}
+
+		t := time.NewTimer(backoff(n + 1))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return errors.Join(err, ctx.Err())
+		}
+	}
+}
+
+func runTx(ctx context.Context, db TxDB, opts *TxOptions, fn func(ctx context.Context, tx *Tx) error) (err error) {
+	ctx, tx, err := BeginTx(ctx, db, &sql.TxOptions{Isolation: opts.Isolation, ReadOnly: opts.ReadOnly})
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	// also rolls back if fn panics or calls runtime.Goexit, and does nothing once committed
+	defer func() {
+		if rerr := tx.Rollback(); err != nil && rerr != nil && !errors.Is(rerr, sql.ErrTxDone) {
+			err = errors.Join(err, fmt.Errorf("rollback: %w", rerr))
+		}
+	}()
+
+	if err := fn(ctx, tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+func isRetryable(err error) bool {
+	switch ClassifyError(err).Kind {
+	case ErrorBusy, ErrorSerializationFailure:
+		return true
+	default:
+		return false
+	}
+}
+
+func defaultBackoff(n int) time.Duration {
+	const base, limit = 10 * time.Millisecond, time.Second
+
+	d := limit
+	if n < 8 {
+		d = min(base<<(n-1), limit)
+	}
+	// jitter to spread out retries of transactions that conflicted with each other
+	return d/2 + rand.N(d/2+1) //nolint:gosec // jitter doesn't need a secure source
+}
+
+// Tx is a transaction started with [BeginTx], which is either a database transaction or a savepoint in one.
+// Its methods mirror [*sql.Tx], so it can be used anywhere sqlb accepts a [DB] or [StmtDB].
+type Tx struct {
+	tx    *sql.Tx
+	db    TxDB // the handle the outermost transaction was begun on
+	depth int  // 0 for the outermost transaction, which isn't a savepoint
+	root  *Tx  // the outermost transaction, which holds the hooks
+	mark  int  // for savepoints, the number of hooks when it began
+	outer *Tx  // for the outermost transaction, one on another database that ctx carried when it began
+
+	mu     sync.Mutex
+	done   bool
+	hooks  []txHook                    // only on the root
+	caches map[*StmtCache]*TxStmtCache // only on the root, see [DBFromContext]
+}
+
+type txHook struct {
+	fn         func()
+	onCommit   bool
+	rolledBack bool // registered in a savepoint that was rolled back, so runs however the transaction ends
+}
+
+type txContextKey struct{}
+
+// BeginTx starts a transaction and returns a context carrying it. If ctx already carries a transaction begun on db,
+// or on a handle db wraps such as the primary of a [Router], a savepoint in it is created instead and opts are ignored,
+// so layers that each want their own transaction can call each other. A transaction on another database is separate.
+// Committing and rolling back a savepoint map to RELEASE SAVEPOINT and ROLLBACK TO SAVEPOINT, which have the same
+// syntax in SQLite, Postgres, and MySQL.
+//
+// Savepoints must be committed or rolled back before their parent. Code given the returned context
+// must not use the parent transaction directly while the savepoint is open.
+func BeginTx(ctx context.Context, db TxDB, opts *sql.TxOptions) (context.Context, *Tx, error) {
+	parent, _ := ctx.Value(txContextKey{}).(*Tx)
+	if parent != nil && parent.begunOn(db) {
+		t := &Tx{tx: parent.tx, db: parent.db, depth: parent.depth + 1, root: parent.root}
+		t.root.mu.Lock()
+		t.mark = len(t.root.hooks)
+		t.root.mu.Unlock()
+		if _, err := t.tx.ExecContext(ctx, "SAVEPOINT "+t.savepoint()); err != nil {
+			return ctx, nil, fmt.Errorf("savepoint: %w", err)
+		}
+		return context.WithValue(ctx, txContextKey{}, t), t, nil
+	}
+
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return ctx, nil, err
+	}
+	t := &Tx{tx: tx, db: db, outer: parent}
+	t.root = t
+	return context.WithValue(ctx, txContextKey{}, t), t, nil
+}
+
+// Commit commits the transaction, or releases the savepoint. Like [sql.Tx.Commit], it returns [sql.ErrTxDone]
+// if the transaction has already been committed or rolled back.
+func (t *Tx) Commit() error {
+	if t.depth == 0 {
+		if !t.finish() {
+			return sql.ErrTxDone
+		}
+		// the transaction is rolled back if committing fails, including when database/sql already
+		// rolled it back because its context was cancelled
+		err := t.tx.Commit()
+		t.runHooks(err == nil)
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.done {
+		return sql.ErrTxDone
+	}
+	// a failed release leaves the savepoint open, to be rolled back
+	if _, err := t.tx.ExecContext(context.Background(), "RELEASE SAVEPOINT "+t.savepoint()); err != nil {
+		return fmt.Errorf("release savepoint: %w", err)
+	}
+	t.done = true
+	return nil
+}
+
+// Rollback aborts the transaction, or rolls back to and releases the savepoint. Like [sql.Tx.Rollback], it returns
+// [sql.ErrTxDone] if the transaction has already been committed or rolled back, so it is safe to defer.
+func (t *Tx) Rollback() error {
+	if t.depth == 0 {
+		if !t.finish() {
+			return sql.ErrTxDone
+		}
+		// database/sql returns sql.ErrTxDone if it rolled back already because its context was cancelled
+		err := t.tx.Rollback()
+		t.runHooks(false)
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	t.root.rollbackHooks(t.mark)
+	// rolling back to a savepoint keeps it open, so it's released after
+	if _, err := t.tx.ExecContext(context.Background(), "ROLLBACK TO SAVEPOINT "+t.savepoint()); err != nil {
+		return fmt.Errorf("rollback to savepoint: %w", err)
+	}
+	if _, err := t.tx.ExecContext(context.Background(), "RELEASE SAVEPOINT "+t.savepoint()); err != nil {
+		return fmt.Errorf("release savepoint: %w", err)
+	}
+	return nil
+}
+
+func (t *Tx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return t.tx.QueryContext(ctx, query, args...)
+}
+
+func (t *Tx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return t.tx.ExecContext(ctx, query, args...)
+}
+
+func (t *Tx) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return t.tx.PrepareContext(ctx, query)
+}
+
+func (t *Tx) StmtContext(ctx context.Context, stmt *sql.Stmt) *sql.Stmt {
+	return t.tx.StmtContext(ctx, stmt)
+}
+
+// begunOn reports whether db is the handle the transaction was begun on, or either wraps the other's handle.
+func (t *Tx) begunOn(db any) bool {
+	for a := db; a != nil; a = unwrapDB(a) {
+		for b := any(t.db); b != nil; b = unwrapDB(b) {
+			if sameDB(a, b) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bind returns the equivalent of db, which the transaction was begun on, that runs in the transaction. See [DBFromContext].
+func (t *Tx) bind(db any) DB {
+	switch db := db.(type) {
+	case *StmtCache:
+		return t.root.stmtCache(db)
+	case *MiddlewareDB:
+		return Wrap(t.bind(db.db), db.mw...)
+	case *Router:
+		return t.bind(db.primary)
+	default:
+		return t
+	}
+}
+
+// savepoint returns a name unique among open savepoints, since they're closed innermost first.
+func (t *Tx) savepoint() string {
+	return "sqlb_" + strconv.Itoa(t.depth)
+}
+
+// stmtCache returns the view of sc bound to the transaction, reused for the transaction's lifetime.
+func (t *Tx) stmtCache(sc *StmtCache) *TxStmtCache {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if tc, ok := t.caches[sc]; ok {
+		return tc
+	}
+	if t.caches == nil {
+		t.caches = make(map[*StmtCache]*TxStmtCache)
+	}
+	tc := sc.Tx(t)
+	t.caches[sc] = tc
+	return tc
+}
+
+// finish marks the outermost transaction done, reporting whether it wasn't already, so hooks run exactly once.
+func (t *Tx) finish() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.done {
+		return false
+	}
+	t.done = true
+	return true
+}
+
+func (t *Tx) addHook(h txHook) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.hooks = append(t.hooks, h)
+	return nil
+}
+
+// rollbackHooks discards commit hooks registered since mark, and keeps rollback hooks to run however the transaction ends.
+func (t *Tx) rollbackHooks(mark int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	kept := t.hooks[:mark]
+	for _, h := range t.hooks[mark:] {
+		if h.onCommit {
+			continue
+		}
+		h.rolledBack = true
+		kept = append(kept, h)
+	}
+	t.hooks = kept
+}
+
+// runHooks runs every hook even if one panics, then panics with the first hook's panic.
+func (t *Tx) runHooks(committed bool) {
+	t.mu.Lock()
+	hooks := t.hooks
+	t.hooks = nil
+	t.mu.Unlock()
+
+	var first any
+	for _, h := range hooks {
+		if h.onCommit == committed || h.rolledBack {
+			func() {
+				defer func() {
+					if p := recover(); p != nil && first == nil {
+						first = p
+					}
+				}()
+				h.fn()
+			}()
+		}
+	}
+	if first != nil {
+		panic(first)
+	}
+}
+
+// OnCommit registers fn to be called after the outermost transaction from [BeginTx] or [InTx] in ctx commits, in order
+// of registration. fn is not called if the transaction, or the savepoint it was registered in, is rolled back.
+// It returns [ErrNoTx] if ctx has no transaction, or [sql.ErrTxDone] if it has already finished, and fn is never called.
+//
+// A panic in a hook doesn't stop the others. Once they have all run, Commit or Rollback panics with the first one.
+func OnCommit(ctx context.Context, fn func()) error {
+	t, ok := ctx.Value(txContextKey{}).(*Tx)
+	if !ok {
+		return ErrNoTx
+	}
+	return t.root.addHook(txHook{fn: fn, onCommit: true})
+}
+
+// OnRollback registers fn to be called after the outermost transaction from [BeginTx] or [InTx] in ctx is rolled back,
+// including by database/sql when its context is cancelled, in order of registration with [OnCommit] hooks. If it was
+// registered in a savepoint that is rolled back, fn is called once the outermost transaction finishes, even if it
+// commits. It returns the same errors as OnCommit.
+func OnRollback(ctx context.Context, fn func()) error {
+	t, ok := ctx.Value(txContextKey{}).(*Tx)
+	if !ok {
+		return ErrNoTx
+	}
+	return t.root.addHook(txHook{fn: fn})
+}
+
+// unwrapDB returns the handle that db runs transactions and statements on, or nil if it doesn't wrap one.
+func unwrapDB(db any) any {
+	switch db := db.(type) {
+	case *StmtCache:
+		return db.db
+	case *MiddlewareDB:
+		return db.db
+	case *Router:
+		return db.primary
+	case *SQLiteDB:
+		return db.Writer
+	default:
+		return nil
+	}
+}
+
+// sameDB reports whether a and b are the same handle, without panicking for types that can't be compared.
+func sameDB(a, b any) bool {
+	ta := reflect.TypeOf(a)
+	return ta != nil && ta == reflect.TypeOf(b) && ta.Comparable() && a == b
+}
@@ -0,0 +1,576 @@
+package sqlb_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"go.senan.xyz/sqlb"
+)
+
+func ExampleInTx() {
+	ctx := context.Background()
+	db := newDB(ctx)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	opts := &sqlb.TxOptions{MaxRetries: 3}
+	err := sqlb.InTx(ctx, db, opts, func(ctx context.Context, tx *sqlb.Tx) error {
+		var task Task
+		if err := sqlb.QueryRow(ctx, tx, &task, "INSERT INTO tasks (name) VALUES (?) RETURNING *", "alice"); err != nil {
+			return err
+		}
+		return sqlb.Exec(ctx, tx, "UPDATE tasks SET age = ? WHERE id = ?", 30, task.ID)
+	})
+	fmt.Println(err)
+
+	var age int
+	_ = sqlb.QueryRow(ctx, db, sqlb.Scan(&age), "SELECT age FROM tasks WHERE name = ?", "alice")
+	fmt.Println(age)
+	// Output:
+	// <nil>
+	// 30
+}
+
+func TestInTxRollback(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	errBoom := errors.New("boom")
+	err := sqlb.InTx(ctx, db, nil, func(ctx context.Context, tx *sqlb.Tx) error {
+		if err := sqlb.Exec(ctx, tx, "INSERT INTO tasks (name) VALUES (?)", "a"); err != nil {
+			return err
+		}
+		return errBoom
+	})
+	if !errors.Is(err, errBoom) {
+		t.Errorf("got %v, want %v", err, errBoom)
+	}
+
+	func() {
+		defer func() {
+			if p := recover(); p != "panic" {
+				t.Errorf("got panic %v", p)
+			}
+		}()
+		_ = sqlb.InTx(ctx, db, nil, func(ctx context.Context, tx *sqlb.Tx) error {
+			_ = sqlb.Exec(ctx, tx, "INSERT INTO tasks (name) VALUES (?)", "b")
+			panic("panic")
+		})
+	}()
+
+	// runtime.Goexit, as from t.FailNow, skips recover but still runs deferred calls
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = sqlb.InTx(ctx, db, nil, func(ctx context.Context, tx *sqlb.Tx) error {
+			_ = sqlb.Exec(ctx, tx, "INSERT INTO tasks (name) VALUES (?)", "c")
+			runtime.Goexit()
+			return nil
+		})
+	}()
+	<-done
+
+	// a nested call rolls back only its savepoint
+	err = sqlb.InTx(ctx, db, nil, func(ctx context.Context, tx *sqlb.Tx) error {
+		if err := sqlb.Exec(ctx, tx, "INSERT INTO tasks (name) VALUES (?)", "d"); err != nil {
+			return err
+		}
+		err := sqlb.InTx(ctx, db, nil, func(ctx context.Context, tx *sqlb.Tx) error {
+			if err := sqlb.Exec(ctx, tx, "INSERT INTO tasks (name) VALUES (?)", "e"); err != nil {
+				return err
+			}
+			return errBoom
+		})
+		if !errors.Is(err, errBoom) {
+			t.Errorf("got %v, want %v", err, errBoom)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var count int
+	if err := sqlb.QueryRow(ctx, db, sqlb.Scan(&count), "SELECT count(*) FROM tasks"); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("got %d tasks, want 1", count)
+	}
+}
+
+func TestInTxRetry(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	var backoffs []int
+	opts := &sqlb.TxOptions{
+		MaxRetries: 3,
+		Backoff: func(n int) time.Duration {
+			backoffs = append(backoffs, n)
+			return 0
+		},
+	}
+
+	var attempts int
+	err := sqlb.InTx(ctx, db, opts, func(ctx context.Context, tx *sqlb.Tx) error {
+		attempts++
+		if err := sqlb.Exec(ctx, tx, "INSERT INTO tasks (name) VALUES (?)", "a"); err != nil {
+			return err
+		}
+		if attempts < 3 {
+			return fmt.Errorf("wrapped: %w", pgError{"40001", "could not serialize access"})
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 || fmt.Sprint(backoffs) != "[1 2]" {
+		t.Errorf("got %d attempts and backoffs %v", attempts, backoffs)
+	}
+
+	var count int
+	if err := sqlb.QueryRow(ctx, db, sqlb.Scan(&count), "SELECT count(*) FROM tasks"); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("got %d tasks, want 1", count)
+	}
+
+	// gives up after MaxRetries, and doesn't retry other errors
+	attempts = 0
+	err = sqlb.InTx(ctx, db, opts, func(ctx context.Context, tx *sqlb.Tx) error {
+		attempts++
+		return errors.New("sqlite3: database is locked")
+	})
+	if !sqlb.IsBusy(err) || attempts != 4 {
+		t.Errorf("got %v after %d attempts", err, attempts)
+	}
+	attempts = 0
+	_ = sqlb.InTx(ctx, db, opts, func(ctx context.Context, tx *sqlb.Tx) error {
+		attempts++
+		return errors.New("boom")
+	})
+	if attempts != 1 {
+		t.Errorf("got %d attempts for non-retryable error, want 1", attempts)
+	}
+}
+
+func TestInTxRetryCancel(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(t.Context())
+	db := newDB(ctx)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	opts := &sqlb.TxOptions{MaxRetries: 1, Backoff: func(int) time.Duration { return time.Hour }}
+	err := sqlb.InTx(ctx, db, opts, func(ctx context.Context, tx *sqlb.Tx) error {
+		cancel()
+		return pgError{"40P01", "deadlock detected"}
+	})
+	if !errors.Is(err, context.Canceled) || !sqlb.IsSerializationFailure(err) {
+		t.Errorf("got %v, want cancelled serialization failure", err)
+	}
+}
+
+func TestInTxReadOnly(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	err := sqlb.InTx(ctx, db, &sqlb.TxOptions{ReadOnly: true}, func(ctx context.Context, tx *sqlb.Tx) error {
+		return sqlb.Exec(ctx, tx, "INSERT INTO tasks (name) VALUES (?)", "a")
+	})
+	if err == nil {
+		t.Error("expected error writing in read-only transaction")
+	}
+}
+
+func ExampleBeginTx() {
+	ctx := context.Background()
+	db := newDB(ctx)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	createTask := func(ctx context.Context, name string) error {
+		ctx, tx, err := sqlb.BeginTx(ctx, db, nil) // a savepoint if ctx already has a transaction
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if err := sqlb.Exec(ctx, tx, "INSERT INTO tasks (name) VALUES (?)", name); err != nil {
+			return err
+		}
+		if name == "" {
+			return errors.New("empty name")
+		}
+		return tx.Commit()
+	}
+
+	ctx, tx, _ := sqlb.BeginTx(ctx, db, nil)
+	fmt.Println(createTask(ctx, "a"))
+	fmt.Println(createTask(ctx, ""))
+	_ = tx.Commit()
+
+	var names []string
+	_ = sqlb.QueryRows(ctx, db, sqlb.AppendValue(&names), "SELECT name FROM tasks")
+	fmt.Println(names)
+	// Output:
+	// <nil>
+	// empty name
+	// [a]
+}
+
+func TestBeginTxNested(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	insert := func(ctx context.Context, tx *sqlb.Tx, name string) {
+		t.Helper()
+		if err := sqlb.Exec(ctx, tx, "INSERT INTO tasks (name) VALUES (?)", name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	begin := func(ctx context.Context) (context.Context, *sqlb.Tx) {
+		t.Helper()
+		ctx, tx, err := sqlb.BeginTx(ctx, db, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ctx, tx
+	}
+
+	ctx0, tx0 := begin(ctx)
+	insert(ctx0, tx0, "a")
+
+	// a committed savepoint is still rolled back with its parent
+	ctx1, tx1 := begin(ctx0)
+	insert(ctx1, tx1, "b")
+	ctx2, tx2 := begin(ctx1)
+	insert(ctx2, tx2, "c")
+	if err := tx2.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx1.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	// sibling savepoints reuse the name for their depth
+	ctx1, tx1 = begin(ctx0)
+	insert(ctx1, tx1, "d")
+	ctx2, tx2 = begin(ctx1)
+	insert(ctx2, tx2, "e")
+	if err := tx2.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx1.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tx1.Rollback(); !errors.Is(err, sql.ErrTxDone) {
+		t.Errorf("got %v, want %v", err, sql.ErrTxDone)
+	}
+	if err := tx0.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx0.Rollback(); !errors.Is(err, sql.ErrTxDone) {
+		t.Errorf("got %v, want %v", err, sql.ErrTxDone)
+	}
+
+	var names []string
+	if err := sqlb.QueryRows(ctx, db, sqlb.AppendValue(&names), "SELECT name FROM tasks ORDER BY id"); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(names); got != "[a d e]" {
+		t.Errorf("got %s, want [a d e]", got)
+	}
+}
+
+func TestBeginTxOtherDB(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	dbA, dbB := newDB(ctx), newDB(ctx)
+	defer dbA.Close()
+	defer dbB.Close()
+	dbA.SetMaxOpenConns(1)
+	dbB.SetMaxOpenConns(1)
+
+	ctxA, txA, err := sqlb.BeginTx(ctx, dbA, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a transaction on another database isn't a savepoint in the one from ctx
+	ctxB, txB, err := sqlb.BeginTx(ctxA, dbB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sqlb.Exec(ctxB, txB, "INSERT INTO tasks (name) VALUES (?)", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := txB.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sqlb.Exec(ctxA, txA, "INSERT INTO tasks (name) VALUES (?)", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := txA.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		db   *sql.DB
+		want int
+	}{{dbA, 0}, {dbB, 1}} {
+		var n int
+		if err := sqlb.QueryRow(ctx, c.db, sqlb.Scan(&n), "SELECT count(*) FROM tasks"); err != nil {
+			t.Fatal(err)
+		}
+		if n != c.want {
+			t.Errorf("got %d tasks, want %d", n, c.want)
+		}
+	}
+}
+
+func TestBeginTxStmtCache(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	cache := sqlb.NewStmtCache(db)
+	defer cache.Close()
+	if err := sqlb.Exec(ctx, cache, "INSERT INTO tasks (name) VALUES (?)", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, tx, err := sqlb.BeginTx(ctx, db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	ctx, sp, err := sqlb.BeginTx(ctx, db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sqlb.Exec(ctx, cache.Tx(sp), "INSERT INTO tasks (name) VALUES (?)", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sp.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	var count int
+	if err := sqlb.QueryRow(ctx, db, sqlb.Scan(&count), "SELECT count(*) FROM tasks"); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("got %d tasks, want 1", count)
+	}
+}
+
+func ExampleOnCommit() {
+	ctx := context.Background()
+	db := newDB(ctx)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	ctx, tx, _ := sqlb.BeginTx(ctx, db, nil)
+	defer tx.Rollback()
+
+	_ = sqlb.Exec(ctx, tx, "INSERT INTO tasks (name) VALUES (?)", "alice")
+	_ = sqlb.OnCommit(ctx, func() { fmt.Println("sending welcome email") })
+	_ = sqlb.OnRollback(ctx, func() { fmt.Println("not sending") })
+
+	fmt.Println("committing")
+	_ = tx.Commit()
+	// Output:
+	// committing
+	// sending welcome email
+}
+
+func TestTxHooks(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	run := func(commit bool) []string {
+		t.Helper()
+
+		var calls []string
+		hooks := func(ctx context.Context, name string) {
+			t.Helper()
+			if err := sqlb.OnCommit(ctx, func() { calls = append(calls, "commit "+name) }); err != nil {
+				t.Fatal(err)
+			}
+			if err := sqlb.OnRollback(ctx, func() { calls = append(calls, "rollback "+name) }); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		ctx0, tx0, err := sqlb.BeginTx(ctx, db, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hooks(ctx0, "a")
+
+		ctx1, tx1, err := sqlb.BeginTx(ctx0, db, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hooks(ctx1, "b")
+		ctx2, _, err := sqlb.BeginTx(ctx1, db, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hooks(ctx2, "c")
+		if err := tx1.Rollback(); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx1, tx1, err = sqlb.BeginTx(ctx0, db, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hooks(ctx1, "d")
+		if err := tx1.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(calls) != 0 {
+			t.Errorf("hooks called before outermost transaction finished: %q", calls)
+		}
+
+		if commit {
+			err = tx0.Commit()
+		} else {
+			err = tx0.Rollback()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = tx0.Rollback() // doesn't run hooks again
+		return calls
+	}
+
+	if got, want := run(true), []string{"commit a", "rollback b", "rollback c", "commit d"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("commit: got %q, want %q", got, want)
+	}
+	if got, want := run(false), []string{"rollback a", "rollback b", "rollback c", "rollback d"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("rollback: got %q, want %q", got, want)
+	}
+
+	hook := func() { t.Error("hook called without transaction") }
+	if err := sqlb.OnCommit(ctx, hook); !errors.Is(err, sqlb.ErrNoTx) {
+		t.Errorf("got %v, want %v", err, sqlb.ErrNoTx)
+	}
+	if err := sqlb.OnRollback(ctx, hook); !errors.Is(err, sqlb.ErrNoTx) {
+		t.Errorf("got %v, want %v", err, sqlb.ErrNoTx)
+	}
+
+	txCtx, tx, err := sqlb.BeginTx(ctx, db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sqlb.OnCommit(txCtx, hook); !errors.Is(err, sql.ErrTxDone) {
+		t.Errorf("got %v, want %v", err, sql.ErrTxDone)
+	}
+}
+
+func TestTxHooksCancel(t *testing.T) {
+	t.Parallel()
+	db := newDB(t.Context())
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	for _, finish := range []string{"commit", "rollback"} {
+		ctx, cancel := context.WithCancel(t.Context())
+		ctx, tx, err := sqlb.BeginTx(ctx, db, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var calls int
+		if err := sqlb.OnRollback(ctx, func() { calls++ }); err != nil {
+			t.Fatal(err)
+		}
+		if err := sqlb.OnCommit(ctx, func() { t.Error("commit hook called after cancel") }); err != nil {
+			t.Fatal(err)
+		}
+
+		// database/sql rolls back the transaction itself once its context is cancelled
+		cancel()
+		if finish == "commit" {
+			if err := tx.Commit(); err == nil {
+				t.Errorf("%s: expected error committing after cancel", finish)
+			}
+		}
+		_ = tx.Rollback()
+		_ = tx.Rollback()
+		if calls != 1 {
+			t.Errorf("%s: got %d rollback hook calls, want 1", finish, calls)
+		}
+	}
+}
+
+func TestTxHooksPanic(t *testing.T) {
+	t.Parallel()
+	ctx := t.Context()
+	db := newDB(ctx)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	ctx, tx, err := sqlb.BeginTx(ctx, db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls []string
+	for _, name := range []string{"a", "b", "c"} {
+		err := sqlb.OnCommit(ctx, func() {
+			calls = append(calls, name)
+			if name != "c" {
+				panic(name)
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	func() {
+		defer func() {
+			if p := recover(); p != "a" {
+				t.Errorf("got panic %v, want a", p)
+			}
+		}()
+		_ = tx.Commit()
+	}()
+	if got, want := calls, []string{"a", "b", "c"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}